@@ -3,8 +3,11 @@ package auth
 // Root package with domain types
 
 import (
+	"crypto/x509"
+	"encoding/json"
 	"net/http"
 	"net/netip"
+	"slices"
 	"time"
 
 	"github.com/google/uuid"
@@ -13,10 +16,24 @@ import (
 type UUID = uuid.UUID
 
 type User struct {
-	UUID          UUID            `json:"uuid" db:"uuid"`
-	Email         string          `json:"email" db:"email"`
-	Password      string          `json:"-" db:"password"`
-	RefreshTokens []*RefreshToken `json:"-" db:"refresh_tokens"`
+	UUID          UUID   `json:"uuid" db:"uuid"`
+	Email         string `json:"email" db:"email"`
+	Password      string `json:"-" db:"password"`
+	EmailVerified bool   `json:"emailVerified" db:"email_verified"`
+	// When the password was last changed or reset, nil if it hasn't been since registration.
+	// Tokens issued before then are rejected
+	PasswordChangedAt *time.Time `json:"passwordChangedAt" db:"password_changed_at"`
+	// Until when password login is locked after too many failed attempts, nil if it has never been.
+	// Attempts are counted since the last successful login or lock. Only exposed through ToLockout
+	LockedUntil         *time.Time      `json:"-" db:"locked_until"`
+	FailedLoginAttempts int             `json:"-" db:"failed_login_attempts"`
+	LastFailedLoginIP   string          `json:"-" db:"last_failed_login_ip"`
+	LastFailedLoginAt   *time.Time      `json:"-" db:"last_failed_login_at"`
+	RefreshTokens       []*RefreshToken `json:"-" db:"refresh_tokens"`
+}
+
+func (u User) IsLocked(at time.Time) bool {
+	return u.LockedUntil != nil && u.LockedUntil.After(at)
 }
 
 type PublicUser struct {
@@ -29,27 +46,264 @@ func (u User) ToPublic() *PublicUser {
 	}
 }
 
+// AccountLockout tells support whether the user is being attacked or has forgotten their password
+type AccountLockout struct {
+	Locked              bool       `json:"locked"`
+	LockedUntil         *time.Time `json:"lockedUntil"`
+	FailedLoginAttempts int        `json:"failedLoginAttempts"`
+	LastFailedLoginIP   string     `json:"lastFailedLoginIp"`
+	LastFailedLoginAt   *time.Time `json:"lastFailedLoginAt"`
+}
+
+func (u User) ToLockout(at time.Time) *AccountLockout {
+	return &AccountLockout{
+		Locked:              u.IsLocked(at),
+		LockedUntil:         u.LockedUntil,
+		FailedLoginAttempts: u.FailedLoginAttempts,
+		LastFailedLoginIP:   u.LastFailedLoginIP,
+		LastFailedLoginAt:   u.LastFailedLoginAt,
+	}
+}
+
 type CreateUserDto struct {
 	Email    string `json:"email" validate:"required,email,max=254"`
 	Password string `json:"password" validate:"required,password,min=8"`
 }
 
 type UpdateUserDto struct {
-	Email    string `json:"email" validate:"omitempty,email,max=254"`
+	Email string `json:"email" validate:"omitempty,email,max=254"`
+	// Rejected, as changing password requires the current one, see ChangePasswordDto
 	Password string `json:"password" validate:"omitempty,password,min=8"`
 }
 
 type LoginUserDto struct {
 	Email    string `json:"email" db:"email"`
 	Password string `json:"password" db:"password"`
+	// (optional) Space-delimited scopes to restrict the session to, all scopes by default
+	Scope string `json:"scope"`
+}
+
+type RefreshDto struct {
+	AccessToken  string `json:"accessToken"`
+	RefreshToken string `json:"refreshToken"`
+	// (optional) Space-delimited scopes to restrict the access token to, all scopes of the session by default
+	Scope string `json:"scope"`
+}
+
+type VerifyEmailDto struct {
+	Token string `json:"token" validate:"required"`
+}
+
+type ChangePasswordDto struct {
+	CurrentPassword string `json:"currentPassword" validate:"required"`
+	NewPassword     string `json:"newPassword" validate:"required,password,min=8"`
+}
+
+type TOTPCodeDto struct {
+	Code string `json:"code" validate:"required,numeric,len=6"`
+}
+
+type RegenerateRecoveryCodesDto struct {
+	// Required along with the code, so that a stolen access token and a glimpse of the authenticator app aren't enough
+	Password string `json:"password" validate:"required"`
+	Code     string `json:"code" validate:"required,numeric,len=6"`
+}
+
+type LoginMFADto struct {
+	// Challenge token returned by Login instead of tokens
+	MFAToken string `json:"mfaToken" validate:"required"`
+	// Either a code from the authenticator app or one of the recovery codes
+	Code         string `json:"code" validate:"required_without=RecoveryCode,excluded_with=RecoveryCode,omitempty,numeric,len=6"`
+	RecoveryCode string `json:"recoveryCode" validate:"required_without=Code,omitempty,max=32"`
+}
+
+type ForgotPasswordDto struct {
+	Email string `json:"email" validate:"required,email,max=254"`
+}
+
+type MagicLinkDto struct {
+	Email string `json:"email" validate:"required,email,max=254"`
+}
+
+type RedeemMagicLinkDto struct {
+	Token string `json:"token" validate:"required"`
+	// (optional) Space-delimited scopes to restrict the session to, all scopes by default
+	Scope string `json:"scope"`
+}
+
+type UnlockAccountDto struct {
+	Token string `json:"token" validate:"required"`
+}
+
+type ResetPasswordDto struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required,password,min=8"`
+}
+
+type BeginWebAuthnRegistrationDto struct {
+	// Required, so that a stolen access token isn't enough to add a passkey to the account
+	Password string `json:"password" validate:"required"`
+}
+
+type FinishWebAuthnRegistrationDto struct {
+	// Returned by the begin step along with the options
+	CeremonyID string `json:"ceremonyId" validate:"required,uuid"`
+	// PublicKeyCredential returned by `navigator.credentials.create()`, serialized with its `toJSON()`
+	Credential json.RawMessage `json:"credential" validate:"required"`
+}
+
+type FinishWebAuthnLoginDto struct {
+	// Returned by the begin step along with the options
+	CeremonyID string `json:"ceremonyId" validate:"required,uuid"`
+	// PublicKeyCredential returned by `navigator.credentials.get()`, serialized with its `toJSON()`
+	Credential json.RawMessage `json:"credential" validate:"required"`
+	// (optional) Space-delimited scopes to restrict the session to, all scopes by default
+	Scope string `json:"scope"`
 }
 
 type RefreshToken struct {
-	UUID        UUID      `json:"uuid" db:"uuid"`
-	HashedToken string    `json:"hashedToken" db:"hashed_token"`
-	UserUUID    UUID      `json:"userUUID" db:"user_uuid"`
-	Active      bool      `json:"active" db:"active"`
-	CreatedAt   time.Time `json:"createdAt" db:"created_at"`
+	UUID        UUID   `json:"uuid" db:"uuid"`
+	HashedToken string `json:"hashedToken" db:"hashed_token"`
+	UserUUID    UUID   `json:"userUUID" db:"user_uuid"`
+	// Identifies the chain of tokens produced by rotating the token issued on login,
+	// i.e. a single session (device) of the user
+	FamilyUUID UUID `json:"familyUUID" db:"family_uuid"`
+	// Token which was rotated into this one, nil for the first token of the family
+	ParentUUID *UUID `json:"parentUUID" db:"parent_uuid"`
+	// User's IPv4 or IPv6 address (without port) the token was issued to
+	IP        string `json:"ip" db:"ip"`
+	UserAgent string `json:"userAgent" db:"user_agent"`
+	// Space-delimited scopes granted to the session, access tokens can be issued with any subset of them.
+	// Empty for sessions started before scopes were introduced, which have all of them
+	Scope     string    `json:"scope" db:"scope"`
+	Active    bool      `json:"active" db:"active"`
+	CreatedAt time.Time `json:"createdAt" db:"created_at"`
+}
+
+// Session is a refresh token family as seen by the user, i.e. a device they are logged in on
+type Session struct {
+	UUID      UUID   `json:"uuid"`
+	IP        string `json:"ip"`
+	UserAgent string `json:"userAgent"`
+	// When the user logged in
+	CreatedAt time.Time `json:"createdAt"`
+	// When the session's tokens were last refreshed
+	RefreshedAt time.Time `json:"refreshedAt"`
+	// Whether the session is the one making the request
+	Current bool `json:"current"`
+}
+
+// Roles group permissions, which users are granted through the roles assigned to them.
+// Users without roles can only access their own records
+const (
+	RoleAdmin = "admin"
+)
+
+// Scopes restrict what access tokens can be used for, regardless of who they were issued to,
+// e.g. a read-only token can be handed to a reporting job
+// ref: https://datatracker.ietf.org/doc/html/rfc6749#section-3.3
+const (
+	ScopeUsersRead      = "users:read"
+	ScopeUsersWrite     = "users:write"
+	ScopeSessionsManage = "sessions:manage"
+)
+
+// All scopes, in their canonical order
+var Scopes = []string{ScopeUsersRead, ScopeUsersWrite, ScopeSessionsManage}
+
+// Permissions allow acting on records of any user
+const (
+	PermissionUsersRead   = "users:read"
+	PermissionUsersCreate = "users:create"
+	PermissionUsersUpdate = "users:update"
+	PermissionUsersDelete = "users:delete"
+	// Allows support to list and revoke sessions of any user, e.g. a stolen one
+	PermissionSessionsManage = "sessions:manage"
+)
+
+// Action is an operation on a resource, authorized by PolicyService
+type Action string
+
+const (
+	ActionUsersRead   Action = "read user"
+	ActionUsersCreate Action = "create user"
+	ActionUsersUpdate Action = "update user"
+	ActionUsersDelete Action = "delete user"
+)
+
+// Resource is what an action is performed on, e.g. user's record
+type Resource struct {
+	// UUID of the user owning the resource
+	OwnerUUID UUID
+}
+
+// PolicyService decides whether the principal may perform the action on the resource,
+// which is nil for actions not targeting an existing resource, e.g. creating one
+type PolicyService interface {
+	Authorize(principal *Principal, action Action, resource *Resource) error
+}
+
+// IPChangeAction is taken on refresh when the client's ip address differs from the one the session was last refreshed from
+type IPChangeAction string
+
+// Ordered from the most lenient to the strictest
+const (
+	IPChangeActionIgnore IPChangeAction = "ignore"
+	// Refresh succeeds, and the user is emailed about the new address
+	IPChangeActionNotify IPChangeAction = "notify"
+	// Refresh is rejected, but the session is kept, so the user has to log in again from the new address
+	IPChangeActionReauthenticate IPChangeAction = "reauthenticate"
+	// Refresh is rejected and the session is revoked
+	IPChangeActionDeny IPChangeAction = "deny"
+)
+
+// IPChangePolicy decides what happens when a session moves to another ip address, e.g. admin sessions
+// may be held to stricter rules than those of users hopping between mobile networks
+type IPChangePolicy interface {
+	Decide(roles []string, previous netip.Addr, current netip.Addr) IPChangeAction
+}
+
+type SecurityEventType string
+
+const (
+	// Already rotated refresh token was presented again
+	SecurityEventRefreshTokenReuse SecurityEventType = "refresh_token_reuse"
+	// Password was changed by the user, who provided the current one
+	SecurityEventPasswordChange SecurityEventType = "password_change"
+	// Password was reset with a token sent to user's email
+	SecurityEventPasswordReset SecurityEventType = "password_reset"
+	// Second factor was enabled or disabled
+	SecurityEventMFAEnabled  SecurityEventType = "mfa_enabled"
+	SecurityEventMFADisabled SecurityEventType = "mfa_disabled"
+	// Recovery code was used in place of the second factor
+	SecurityEventRecoveryCodeUsed SecurityEventType = "recovery_code_used"
+	// New set of recovery codes was generated, invalidating the previous one
+	SecurityEventRecoveryCodesRegenerated SecurityEventType = "recovery_codes_regenerated"
+	// Passkey was registered or removed
+	SecurityEventPasskeyAdded   SecurityEventType = "passkey_added"
+	SecurityEventPasskeyRemoved SecurityEventType = "passkey_removed"
+	// Signature counter of the passkey went backwards, i.e. the authenticator may have been cloned
+	SecurityEventPasskeyCloneWarning SecurityEventType = "passkey_clone_warning"
+	// Password login was locked after too many failed attempts, or unlocked by the user or an admin
+	SecurityEventAccountLocked   SecurityEventType = "account_locked"
+	SecurityEventAccountUnlocked SecurityEventType = "account_unlocked"
+	// Service client logged in as the user by their UUID, without their credentials
+	SecurityEventLoginByUUID SecurityEventType = "login_by_uuid"
+	// Session was refreshed from another ip address, details tell the action taken
+	SecurityEventIPChange SecurityEventType = "ip_change"
+	// Session was revoked by someone else than the user, e.g. support
+	SecurityEventSessionRevoked SecurityEventType = "session_revoked"
+)
+
+// SecurityEvent is an audit record of security-relevant activity on user's account
+type SecurityEvent struct {
+	UUID      UUID              `json:"uuid" db:"uuid"`
+	UserUUID  UUID              `json:"userUUID" db:"user_uuid"`
+	Type      SecurityEventType `json:"type" db:"type"`
+	IP        string            `json:"ip" db:"ip"`
+	UserAgent string            `json:"userAgent" db:"user_agent"`
+	Details   string            `json:"details" db:"details"`
+	CreatedAt time.Time         `json:"createdAt" db:"created_at"`
 }
 
 type Tokens struct {
@@ -57,6 +311,108 @@ type Tokens struct {
 	RefreshToken string `json:"refreshToken"`
 }
 
+// Second factors users can complete login with
+const (
+	MFAMethodTOTP         = "totp"
+	MFAMethodRecoveryCode = "recovery_code"
+)
+
+const MFAStatusRequired = "mfa_required"
+
+// MFAChallenge is returned instead of Tokens when the user has a second factor enabled.
+// Login is completed by presenting the token along with the second factor
+type MFAChallenge struct {
+	Status   string `json:"status"`
+	MFAToken string `json:"mfaToken"`
+	// Second factors the user can complete login with
+	Methods []string `json:"methods"`
+}
+
+// TOTPCredential is the secret shared with user's authenticator app
+type TOTPCredential struct {
+	UserUUID UUID   `json:"userUUID" db:"user_uuid"`
+	Secret   string `json:"-" db:"secret"`
+	// Second factor is only required once the user has proven the app is set up, by entering a code
+	Confirmed bool `json:"confirmed" db:"confirmed"`
+	// Time step of the last accepted code, codes of it and earlier steps are rejected
+	LastUsedStep int64     `json:"-" db:"last_used_step"`
+	CreatedAt    time.Time `json:"createdAt" db:"created_at"`
+}
+
+// RecoveryCode is a single-use code which completes login in place of the second factor,
+// e.g. when the phone with the authenticator app is lost. Only its hash is kept
+type RecoveryCode struct {
+	UUID     UUID `json:"uuid" db:"uuid"`
+	UserUUID UUID `json:"userUUID" db:"user_uuid"`
+	// Leading characters of the code, stored as is to find the code without comparing hashes of all of them
+	Lookup     string     `json:"-" db:"lookup"`
+	HashedCode string     `json:"-" db:"hashed_code"`
+	UsedAt     *time.Time `json:"usedAt" db:"used_at"`
+	CreatedAt  time.Time  `json:"createdAt" db:"created_at"`
+}
+
+// RecoveryCodes are shown to the user once, when they are generated
+type RecoveryCodes struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+// TOTPEnrolment is shown to the user once, to add the account to an authenticator app
+type TOTPEnrolment struct {
+	Secret string `json:"secret"`
+	// otpauth:// URI, usually shown as a QR code
+	URI string `json:"uri"`
+}
+
+// WebAuthnCredential is a passkey of the user, i.e. a public key whose private key never leaves
+// the authenticator (phone, security key or password manager)
+type WebAuthnCredential struct {
+	// Credential ID chosen by the authenticator, base64url encoded without padding
+	ID       string `json:"id" db:"id"`
+	UserUUID UUID   `json:"userUUID" db:"user_uuid"`
+	// COSE encoded public key
+	PublicKey []byte `json:"-" db:"public_key"`
+	// Attestation statement format the authenticator used on registration, e.g. `none` or `packed`
+	AttestationType string `json:"attestationType" db:"attestation_type"`
+	// Identifies the authenticator model, all zeros unless attestation was provided
+	AAGUID     UUID     `json:"aaguid" db:"aaguid"`
+	Transports []string `json:"transports" db:"transports"`
+	// Authenticator data flags of the latest ceremony
+	Flags     byte   `json:"-" db:"flags"`
+	SignCount uint32 `json:"-" db:"sign_count"`
+	// Set by login when the signature counter hasn't increased, which means the authenticator
+	// may have been cloned. Not stored
+	CloneWarning bool       `json:"-" db:"-"`
+	CreatedAt    time.Time  `json:"createdAt" db:"created_at"`
+	LastUsedAt   *time.Time `json:"lastUsedAt" db:"last_used_at"`
+}
+
+type WebAuthnCeremony string
+
+const (
+	WebAuthnCeremonyRegistration WebAuthnCeremony = "registration"
+	WebAuthnCeremonyLogin        WebAuthnCeremony = "login"
+)
+
+// WebAuthnSession keeps state of a ceremony, e.g. the challenge, between its begin and finish steps.
+// Each session can only finish a single ceremony
+type WebAuthnSession struct {
+	UUID UUID `json:"uuid" db:"uuid"`
+	// Nil for login, as the user is only known once the authenticator responds
+	UserUUID  *UUID            `json:"userUUID" db:"user_uuid"`
+	Ceremony  WebAuthnCeremony `json:"ceremony" db:"ceremony"`
+	Data      []byte           `json:"-" db:"data"`
+	ExpiresAt time.Time        `json:"expiresAt" db:"expires_at"`
+	CreatedAt time.Time        `json:"createdAt" db:"created_at"`
+}
+
+// WebAuthnOptions are returned by the begin step of a ceremony
+type WebAuthnOptions struct {
+	// Has to be sent back along with the credential on the finish step
+	CeremonyID UUID `json:"ceremonyId"`
+	// To be passed to `navigator.credentials.create()` or `navigator.credentials.get()`
+	Options any `json:"options"`
+}
+
 type AuthService interface {
 	GetUser(uuid UUID) (*User, error)
 	GetUserByEmail(email string) (*User, error)
@@ -64,10 +420,116 @@ type AuthService interface {
 	CreateUser(user *User) (*User, error)
 	UpdateUser(user *User) (*User, error)
 	DeleteUser(uuid UUID) error
+	// Counts a failed password login of the user, returning the number of attempts since the last success or lock
+	RecordFailedLogin(userUUID UUID, ip string, at time.Time) (int, error)
+	// Locks password login until the time, starting the count of failed attempts over
+	LockUser(userUUID UUID, until time.Time) error
+	// Lifts the lock and forgets failed attempts, e.g. after a successful login
+	UnlockUser(userUUID UUID) error
 	AddRefreshToken(refreshToken *RefreshToken) error
+	RotateRefreshToken(refreshTokenUUID UUID, newRefreshToken *RefreshToken) error
 	RevokeRefreshTokensByUser(userUUID UUID) error
-	GetActiveRefreshTokenByUser(userUUID UUID) (*RefreshToken, error)
+	RevokeRefreshTokenFamily(userUUID UUID, familyUUID UUID) error
+	RevokeOtherRefreshTokenFamilies(userUUID UUID, familyUUID UUID) error
+	GetSessionsByUser(userUUID UUID) ([]*Session, error)
+	GetActiveRefreshTokensByUser(userUUID UUID) ([]*RefreshToken, error)
+	GetRefreshTokensByUserSince(userUUID UUID, since time.Time) ([]*RefreshToken, error)
 	GetActiveRefreshToken(uuid UUID) (*RefreshToken, error)
+	GetRefreshToken(uuid UUID) (*RefreshToken, error)
+	GetRefreshTokenByParent(parentUUID UUID) (*RefreshToken, error)
+	GetActiveRefreshTokenByFamily(familyUUID UUID) (*RefreshToken, error)
+	AddSecurityEvent(event *SecurityEvent) error
+	GetTOTPCredential(userUUID UUID) (*TOTPCredential, error)
+	SaveTOTPCredential(credential *TOTPCredential) error
+	ConfirmTOTPCredential(userUUID UUID) error
+	UseTOTPStep(userUUID UUID, step int64) error
+	DeleteTOTPCredential(userUUID UUID) error
+	ReplaceRecoveryCodes(userUUID UUID, codes []*RecoveryCode) error
+	GetRecoveryCodeByLookup(userUUID UUID, lookup string) (*RecoveryCode, error)
+	UseRecoveryCode(uuid UUID) error
+	CountUnusedRecoveryCodes(userUUID UUID) (int, error)
+	DeleteRecoveryCodesByUser(userUUID UUID) error
+	AddOneTimeToken(token *OneTimeToken) error
+	GetOneTimeToken(uuid UUID) (*OneTimeToken, error)
+	UseOneTimeToken(uuid UUID) error
+	DeleteOneTimeTokensByUser(userUUID UUID, purpose ActionPurpose) error
+	AddWebAuthnSession(session *WebAuthnSession) error
+	ConsumeWebAuthnSession(uuid UUID, ceremony WebAuthnCeremony) (*WebAuthnSession, error)
+	AddWebAuthnCredential(credential *WebAuthnCredential) error
+	GetWebAuthnCredentialsByUser(userUUID UUID) ([]*WebAuthnCredential, error)
+	UpdateWebAuthnCredentialUsage(credential *WebAuthnCredential) error
+	DeleteWebAuthnCredential(userUUID UUID, id string) error
+	GetRolesByUser(userUUID UUID) ([]string, error)
+	GetPermissionsByUser(userUUID UUID) ([]string, error)
+	AddUserRole(userUUID UUID, role string) error
+	// Returns users the role has been removed from
+	RemoveRoleFromOtherUsers(role string, userUUIDs []UUID) ([]UUID, error)
+}
+
+// AccessTokenDenylist holds jtis of access tokens revoked before they expired. Entries are only kept
+// until the tokens expire, as from then on the tokens are rejected anyway
+type AccessTokenDenylist interface {
+	Add(jti UUID, expiresAt time.Time) error
+	Contains(jti UUID) (bool, error)
+}
+
+// RateLimitCounter approximates hits within a sliding window from the hits of the current
+// fixed window and the previous one, weighted by how much of it the sliding window still covers
+// ref: https://blog.cloudflare.com/counting-things-a-lot-of-different-things/
+type RateLimitCounter struct {
+	WindowStart time.Time
+	Current     int
+	Previous    int
+	LastHitAt   time.Time
+	// Time of the hit before the last one, zero if there was none, so that the last hit can be told apart
+	// from the ones before it
+	PreviousHitAt time.Time
+}
+
+// Returns the estimated number of hits within the window ending at the time
+func (c RateLimitCounter) Count(window time.Duration, at time.Time) float64 {
+	windowStart := at.Truncate(window)
+	current, previous := c.Current, c.Previous
+	switch {
+	case windowStart.Equal(c.WindowStart):
+	case windowStart.Equal(c.WindowStart.Add(window)):
+		current, previous = 0, c.Current
+	default:
+		return 0
+	}
+
+	previousWeight := 1 - float64(at.Sub(windowStart))/float64(window)
+	return float64(current) + float64(previous)*previousWeight
+}
+
+// RateLimitStore keeps sliding window counters, e.g. of failed logins per account
+type RateLimitStore interface {
+	// Records a hit of the key at the time, returning the updated counter
+	Hit(key string, window time.Duration, at time.Time) (*RateLimitCounter, error)
+	// Returns nil if the key has no recent hits
+	Get(key string) (*RateLimitCounter, error)
+	// Takes back a hit of the current window, if there is any left
+	Undo(key string) error
+	Reset(key string) error
+}
+
+// LoginLimiter slows down password guessing, both against a single account and from a single client,
+// or similarly emails sent on request of clients which haven't logged in. Attempts are counted before
+// they are made, so that concurrent guesses can't all get through before any of them has failed
+type LoginLimiter interface {
+	// Counts an attempt, returning how long the client has to wait if it came too early, in which case
+	// the attempt must not be made. Early attempts are counted all the same, slowing down clients which don't wait
+	Reserve(account string, ip string) (time.Duration, error)
+	// Takes back a reserved attempt which turned out right, e.g. the password was correct
+	Release(account string, ip string) error
+	// Forgets failures of the account, but not of the ip, which might be guessing other accounts
+	RecordSuccess(account string) error
+}
+
+// ClientIPResolver tells the address of the client making the request, which may be behind proxies
+type ClientIPResolver interface {
+	// Returns invalid address if it can't be determined
+	ClientIP(r *http.Request) netip.Addr
 }
 
 type AuthController interface {
@@ -75,11 +537,86 @@ type AuthController interface {
 	GetMe(w http.ResponseWriter, r *http.Request)
 	Login(w http.ResponseWriter, r *http.Request)
 	LoginByUUID(w http.ResponseWriter, r *http.Request)
+	Refresh(w http.ResponseWriter, r *http.Request)
 	GetUsers(w http.ResponseWriter, r *http.Request)
 	CreateUser(w http.ResponseWriter, r *http.Request)
 	Register(w http.ResponseWriter, r *http.Request)
 	UpdateUser(w http.ResponseWriter, r *http.Request)
 	DeleteUser(w http.ResponseWriter, r *http.Request)
+	GetSessions(w http.ResponseWriter, r *http.Request)
+	RevokeSession(w http.ResponseWriter, r *http.Request)
+	RevokeSessions(w http.ResponseWriter, r *http.Request)
+	GetUserSessions(w http.ResponseWriter, r *http.Request)
+	RevokeUserSession(w http.ResponseWriter, r *http.Request)
+	RevokeUserSessions(w http.ResponseWriter, r *http.Request)
+	GetJWKS(w http.ResponseWriter, r *http.Request)
+	VerifyEmail(w http.ResponseWriter, r *http.Request)
+	ResendVerificationEmail(w http.ResponseWriter, r *http.Request)
+	Introspect(w http.ResponseWriter, r *http.Request)
+	Revoke(w http.ResponseWriter, r *http.Request)
+	Logout(w http.ResponseWriter, r *http.Request)
+	ChangePassword(w http.ResponseWriter, r *http.Request)
+	LoginMFA(w http.ResponseWriter, r *http.Request)
+	EnrolTOTP(w http.ResponseWriter, r *http.Request)
+	ConfirmTOTP(w http.ResponseWriter, r *http.Request)
+	DisableTOTP(w http.ResponseWriter, r *http.Request)
+	RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request)
+	ForgotPassword(w http.ResponseWriter, r *http.Request)
+	ResetPassword(w http.ResponseWriter, r *http.Request)
+	SendMagicLink(w http.ResponseWriter, r *http.Request)
+	RedeemMagicLink(w http.ResponseWriter, r *http.Request)
+	UnlockAccount(w http.ResponseWriter, r *http.Request)
+	GetAccountLockout(w http.ResponseWriter, r *http.Request)
+	DeleteAccountLockout(w http.ResponseWriter, r *http.Request)
+	BeginWebAuthnRegistration(w http.ResponseWriter, r *http.Request)
+	FinishWebAuthnRegistration(w http.ResponseWriter, r *http.Request)
+	GetWebAuthnCredentials(w http.ResponseWriter, r *http.Request)
+	DeleteWebAuthnCredential(w http.ResponseWriter, r *http.Request)
+	BeginWebAuthnLogin(w http.ResponseWriter, r *http.Request)
+	FinishWebAuthnLogin(w http.ResponseWriter, r *http.Request)
+}
+
+// Grants which allow service clients to call the endpoints requiring client credentials
+const (
+	ClientGrantIntrospect = "introspect"
+	// Revoking sessions by their tokens, on behalf of the apps holding them
+	ClientGrantRevoke = "revoke"
+	// Issuing tokens for any user by their UUID, without their credentials
+	ClientGrantLoginByUUID = "login_by_uuid"
+)
+
+// Client is an internal service calling the API on its own behalf, rather than on behalf of a user
+type Client struct {
+	ID string
+	// Endpoints the client is allowed to call
+	Grants []string
+}
+
+func (c Client) HasGrant(grant string) bool {
+	return slices.Contains(c.Grants, grant)
+}
+
+type ClientService interface {
+	AuthenticateClient(clientID, clientSecret string) (*Client, error)
+	// Identifies the client by the certificate it presented, which has already been verified against the client CA
+	AuthenticateClientCertificate(cert *x509.Certificate) (*Client, error)
+}
+
+// Introspection response, `active` is the only member present when the token is not active
+// ref: https://datatracker.ietf.org/doc/html/rfc7662#section-2.2
+type IntrospectionResponse struct {
+	Active    bool     `json:"active"`
+	Scope     string   `json:"scope,omitempty"`
+	TokenType string   `json:"token_type,omitempty"`
+	Exp       int64    `json:"exp,omitempty"`
+	Iat       int64    `json:"iat,omitempty"`
+	Nbf       int64    `json:"nbf,omitempty"`
+	Sub       string   `json:"sub,omitempty"`
+	Aud       []string `json:"aud,omitempty"`
+	Iss       string   `json:"iss,omitempty"`
+	Jti       string   `json:"jti,omitempty"`
+	// Session ID, UUID of the refresh token family the token belongs to
+	Sid string `json:"sid,omitempty"`
 }
 
 type ValidationService interface {
@@ -101,6 +638,31 @@ type CryptoService interface {
 	ComparePasswords(hpass string, pass string) error
 }
 
+// TOTPService implements time-based one-time passwords generated by authenticator apps
+type TOTPService interface {
+	GenerateSecret() (string, error)
+	GetURI(secret string, accountName string) string
+	// Returns the time step the code belongs to
+	Validate(secret string, code string, at time.Time) (int64, error)
+}
+
+// WebAuthnService runs FIDO2 WebAuthn ceremonies, i.e. registers passkeys and verifies logins with them.
+// Ceremony state returned by the begin steps is opaque to the caller, who keeps it until the finish step
+// ref: https://www.w3.org/TR/webauthn-3/
+type WebAuthnService interface {
+	BeginRegistration(user *User, credentials []*WebAuthnCredential) (options any, session []byte, err error)
+	// Verifies the attestation and returns the new credential
+	FinishRegistration(user *User, credentials []*WebAuthnCredential, session []byte, response []byte) (*WebAuthnCredential, error)
+	// Login isn't tied to a user, the authenticator lets the user pick one of their passkeys
+	BeginLogin() (options any, session []byte, err error)
+	// Verifies the assertion and returns the user along with the used credential, whose sign count
+	// and flags are updated
+	FinishLogin(session []byte, response []byte, getUser WebAuthnUserHandler) (*User, *WebAuthnCredential, error)
+}
+
+// Returns the user identified by the user handle of the assertion, along with their passkeys
+type WebAuthnUserHandler func(userUUID UUID) (*User, []*WebAuthnCredential, error)
+
 type UUIDService interface {
 	New() UUID
 	Parse(s string) (UUID, error)
@@ -113,6 +675,31 @@ type JWTService interface {
 	VerifyAccessToken(accessToken string) error
 	GetAccessTokenPayload(accessToken string) (*AccessPayload, error)
 	GetRefreshTokenPayload(refreshToken string) (*RefreshPayload, error)
+	GenerateActionToken(payload *ActionPayload) (string, error)
+	GetActionTokenPayload(actionToken string, purpose ActionPurpose) (*ActionPayload, error)
+	GetJWKS() *JWKS
+}
+
+// JSON Web Key, public key which can be used to verify access tokens
+// ref: https://datatracker.ietf.org/doc/html/rfc7517#section-4
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// RSA modulus and exponent
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// EC and OKP curve and coordinates
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JSON Web Key Set
+// ref: https://datatracker.ietf.org/doc/html/rfc7517#section-5
+type JWKS struct {
+	Keys []*JWK `json:"keys"`
 }
 type RefreshPayload struct {
 	// Unique identifier which ensures that:
@@ -121,20 +708,114 @@ type RefreshPayload struct {
 	Jti UUID `json:"jti"`
 	// User's IPv4 or IPv6 address (without port)
 	IP netip.Addr `json:"ip"`
+	// Random bytes only the holder of the token knows. Only their hash is stored,
+	// so the token can't be made up from the jti seen in the access token
+	Secret []byte `json:"-"`
 }
 type AccessPayload struct {
 	// Unique identifier which ensures that:
 	// - each generated token is unique
 	// - refresh and access tokens are coupled
 	Jti UUID `json:"jti"`
+	// Subject, UUID of the user the token was issued to
+	Sub UUID `json:"sub"`
+	// Session ID, UUID of the refresh token family the token belongs to
+	Sid UUID `json:"sid"`
+	// Issuer, identifies the service which issued the token
+	Iss string `json:"iss"`
+	// Audience, services the token is intended for
+	Aud []string `json:"aud"`
 	// User's IPv4 or IPv6 address (without port)
 	IP string `json:"ip"`
 	// Issued at
 	Iat int64 `json:"iat"`
+	// Not before
+	Nbf int64 `json:"nbf"`
 	// Expiration time
 	Exp int64 `json:"exp"`
+	// Space-delimited list of scopes granted to the token, empty when the token isn't restricted
+	Scope string `json:"scope"`
+	// Roles of the user and permissions granted through them at the time the token was issued
+	Roles       []string `json:"roles"`
+	Permissions []string `json:"permissions"`
+}
+
+// Principal is the identity of an authenticated caller, as established from their access token
+type Principal struct {
+	UserUUID    UUID
+	SessionUUID UUID
+	// Access token's jti
+	Jti         UUID
+	Scopes      []string
+	Roles       []string
+	Permissions []string
+}
+
+func (p Principal) HasPermission(permission string) bool {
+	return slices.Contains(p.Permissions, permission)
+}
+
+// Tokens issued before scopes were introduced aren't restricted
+func (p Principal) HasScope(scope string) bool {
+	return len(p.Scopes) == 0 || slices.Contains(p.Scopes, scope)
 }
 
+// ActionPurpose restricts what an action token can be used for, so that e.g. a token
+// sent to verify email can't be used to do anything else
+type ActionPurpose string
+
+const (
+	ActionPurposeVerifyEmail   ActionPurpose = "verify_email"
+	ActionPurposeResetPassword ActionPurpose = "reset_password"
+	ActionPurposeMFA           ActionPurpose = "mfa"
+	ActionPurposeMagicLink     ActionPurpose = "magic_link"
+	ActionPurposeUnlockAccount ActionPurpose = "unlock_account"
+)
+
+// Payload of a short-lived signed token, sent to the user to confirm an action, e.g. by following a link
+type ActionPayload struct {
+	Jti     UUID          `json:"jti"`
+	Purpose ActionPurpose `json:"purpose"`
+	// UUID of the user the action is performed for
+	Sub UUID `json:"sub"`
+	// Email the token was sent to, which becomes outdated once user's email changes
+	Email string `json:"email"`
+	// (optional) Space-delimited scopes the session is requested with, carried over until login is completed
+	Scope string `json:"scope"`
+	Iat   int64  `json:"iat"`
+	Exp   int64  `json:"exp"`
+}
+
+// OneTimeToken is a random token sent to the user to confirm an action, which, unlike an action token,
+// is stored and can only be used once. Only its hash is kept, the same way as with refresh tokens
+type OneTimeToken struct {
+	UUID        UUID          `json:"uuid" db:"uuid"`
+	UserUUID    UUID          `json:"userUUID" db:"user_uuid"`
+	Purpose     ActionPurpose `json:"purpose" db:"purpose"`
+	HashedToken string        `json:"hashedToken" db:"hashed_token"`
+	ExpiresAt   time.Time     `json:"expiresAt" db:"expires_at"`
+	// When the token was used, nil until then
+	UsedAt    *time.Time `json:"usedAt" db:"used_at"`
+	CreatedAt time.Time  `json:"createdAt" db:"created_at"`
+	// Email the token was sent to, so that using it only proves ownership of that email
+	Email string `json:"email" db:"email"`
+}
+
+// EmailVerificationPolicy decides what users can do until they verify their email
+type EmailVerificationPolicy string
+
+const (
+	// Unverified users aren't restricted
+	EmailVerificationPolicyNone EmailVerificationPolicy = "none"
+	// Unverified users are only granted UnverifiedEmailScopes
+	EmailVerificationPolicyRestrict EmailVerificationPolicy = "restrict"
+	// Unverified users can't log in
+	EmailVerificationPolicyBlock EmailVerificationPolicy = "block"
+)
+
+// Scopes granted to users with unverified email under EmailVerificationPolicyRestrict
+var UnverifiedEmailScopes = []string{ScopeUsersRead}
+
 type MailService interface {
 	Send(to, subject, message string) error
 }
@@ -1,21 +1,36 @@
 package main
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	mddl "github.com/go-chi/chi/middleware"
 
+	auth "github.com/medods-technical-assessment"
 	"github.com/medods-technical-assessment/internal/bcrypt"
 	"github.com/medods-technical-assessment/internal/chi"
 	cmddl "github.com/medods-technical-assessment/internal/chi/middleware"
+	"github.com/medods-technical-assessment/internal/clientip"
 	"github.com/medods-technical-assessment/internal/jwt"
+	"github.com/medods-technical-assessment/internal/memory"
+	"github.com/medods-technical-assessment/internal/policy"
 	"github.com/medods-technical-assessment/internal/postgres"
+	"github.com/medods-technical-assessment/internal/ratelimit"
 	"github.com/medods-technical-assessment/internal/smtp"
+	"github.com/medods-technical-assessment/internal/totp"
 	"github.com/medods-technical-assessment/internal/uuid"
 	"github.com/medods-technical-assessment/internal/validator"
+	"github.com/medods-technical-assessment/internal/webauthn"
 
 	// Autoloads `.env`
 	_ "github.com/joho/godotenv/autoload"
@@ -46,24 +61,34 @@ func main() {
 	vs := validator.NewValidationService()
 	cs := bcrypt.NewCryptoService()
 	us := uuid.NewUUIDService()
-	js := jwt.NewJWTService(os.Getenv("JWT_ACCESS_SECRET"), us)
+	js := newJWTService(us)
 	ms := smtp.NewMailService(
 		os.Getenv("SMTP_FROM"),
 		os.Getenv("SMTP_PASSWORD"),
 		os.Getenv("SMTP_HOST"),
 		os.Getenv("SMTP_PORT"),
 		os.Getenv("SMTP_TSL_INSECURE_SKIP_VERIFY"))
+	dl := newAccessTokenDenylist(db)
+	rs := newRateLimitStore(db)
+	ll := ratelimit.NewLoginLimiter(rs, "login", ratelimit.DefaultAccountPolicy, ratelimit.DefaultIPPolicy)
+	el := ratelimit.NewLoginLimiter(rs, "email", ratelimit.DefaultEmailAccountPolicy, ratelimit.DefaultEmailIPPolicy)
+	syncAdminRole(as, os.Getenv("ADMIN_EMAILS"))
+	ps := policy.NewPolicyService(policy.DefaultRules)
+	// Internal services allowed to call endpoints protected by client credentials
+	cls := memory.NewClientService(os.Getenv("SERVICE_CLIENTS"))
+	ts := totp.NewTOTPService(getEnv("TOTP_ISSUER", "auth"))
+	wa := webauthn.NewWebAuthnService(
+		getEnv("WEBAUTHN_RP_ID", "localhost"),
+		getEnv("WEBAUTHN_RP_NAME", "auth"),
+		strings.Split(getEnv("WEBAUTHN_RP_ORIGINS", "http://localhost"), ","))
 	r := chi.NewChiRouter()
 
-	ac := chi.NewAuthController(as, vs, cs, us, js, ms)
+	ac := chi.NewAuthController(as, vs, cs, us, js, ms, dl, ps, ts, wa, ll, el, newClientIPResolver(), newIPChangePolicy(), newAuthControllerConfig())
 
 	r.Use(mddl.StripSlashes)
 
 	// A good base middleware stack
 	r.Use(mddl.RequestID)
-	// Not very trustworthy
-	// ref: https://adam-p.ca/blog/2022/03/x-forwarded-for/#go-chichi
-	r.Use(mddl.RealIP)
 	r.Use(mddl.Logger)
 	r.Use(mddl.Recoverer)
 
@@ -72,40 +97,299 @@ func main() {
 	// processing should be stopped.
 	r.Use(mddl.Timeout(60 * time.Second))
 
+	r.Get("/.well-known/jwks.json", ac.GetJWKS)
+
 	r.Route("/api/v1", func(r chi.Router) {
 		r.Route("/auth", func(r chi.Router) {
 			r.Post("/register", ac.Register)
 			r.Route("/login", func(r chi.Router) {
-				r.With(cmddl.ValidateUUIDParam("UserUUID")).Post("/{UserUUID}", ac.LoginByUUID)
+				r.With(cmddl.ClientAuthentication(cls, auth.ClientGrantLoginByUUID), cmddl.ValidateUUIDParam("UserUUID")).Post("/{UserUUID}", ac.LoginByUUID)
+				r.Post("/mfa", ac.LoginMFA)
+				r.Post("/magic", ac.SendMagicLink)
+				r.Post("/magic/redeem", ac.RedeemMagicLink)
+				r.Post("/webauthn/begin", ac.BeginWebAuthnLogin)
+				r.Post("/webauthn/finish", ac.FinishWebAuthnLogin)
 				r.Post("/", ac.Login)
 			})
 			r.Post("/refresh", ac.Refresh)
-			r.With(cmddl.Authorization(js)).Get("/me", ac.GetMe)
+			r.With(cmddl.ClientAuthentication(cls, auth.ClientGrantIntrospect)).Post("/introspect", ac.Introspect)
+			r.With(cmddl.ClientAuthentication(cls, auth.ClientGrantRevoke)).Post("/revoke", ac.Revoke)
+			r.With(cmddl.Authorization(js, dl)).Post("/logout", ac.Logout)
+			r.With(cmddl.Authorization(js, dl), cmddl.RequireScope(auth.ScopeUsersRead)).Get("/me", ac.GetMe)
+			r.Route("/verify-email", func(r chi.Router) {
+				r.Post("/", ac.VerifyEmail)
+				r.With(cmddl.Authorization(js, dl)).Post("/resend", ac.ResendVerificationEmail)
+			})
+			r.Route("/mfa/totp", func(r chi.Router) {
+				r.Use(cmddl.Authorization(js, dl))
+				r.Post("/", ac.EnrolTOTP)
+				r.Post("/confirm", ac.ConfirmTOTP)
+				r.Delete("/", ac.DisableTOTP)
+			})
+			r.With(cmddl.Authorization(js, dl)).Post("/mfa/recovery-codes", ac.RegenerateRecoveryCodes)
+			r.Route("/webauthn", func(r chi.Router) {
+				r.Use(cmddl.Authorization(js, dl))
+				r.Post("/register/begin", ac.BeginWebAuthnRegistration)
+				r.Post("/register/finish", ac.FinishWebAuthnRegistration)
+				r.Get("/credentials", ac.GetWebAuthnCredentials)
+				r.Delete("/credentials/{CredentialID}", ac.DeleteWebAuthnCredential)
+			})
+			r.Post("/unlock", ac.UnlockAccount)
+			r.Route("/password", func(r chi.Router) {
+				r.With(cmddl.Authorization(js, dl)).Post("/change", ac.ChangePassword)
+				r.Post("/forgot", ac.ForgotPassword)
+				r.Post("/reset", ac.ResetPassword)
+			})
 
-			r.With(cmddl.Authorization(js)).Get("/", ac.GetUsers)
-			r.With(cmddl.Authorization(js)).Post("/", ac.CreateUser)
+			r.Route("/sessions", func(r chi.Router) {
+				r.Use(cmddl.Authorization(js, dl), cmddl.RequireScope(auth.ScopeSessionsManage))
+				r.Get("/", ac.GetSessions)
+				r.Delete("/", ac.RevokeSessions)
+				r.With(cmddl.ValidateUUIDParam("SessionUUID")).Delete("/{SessionUUID}", ac.RevokeSession)
+			})
+
+			r.With(cmddl.Authorization(js, dl), cmddl.RequireScope(auth.ScopeUsersRead), cmddl.RequirePermission(auth.PermissionUsersRead)).Get("/", ac.GetUsers)
+			r.With(cmddl.Authorization(js, dl), cmddl.RequireScope(auth.ScopeUsersWrite), cmddl.RequirePermission(auth.PermissionUsersCreate)).Post("/", ac.CreateUser)
 
 			r.With(cmddl.ValidateUUIDParam("UserUUID")).Group(func(r chi.Router) {
-				r.Get("/{UserUUID}", ac.GetUser)
-				r.With(cmddl.Authorization(js)).Patch("/{UserUUID}", ac.UpdateUser)
-				r.With(cmddl.Authorization(js)).Delete("/{UserUUID}", ac.DeleteUser)
+				r.With(cmddl.Authorization(js, dl), cmddl.RequireScope(auth.ScopeUsersRead)).Get("/{UserUUID}", ac.GetUser)
+				r.With(cmddl.Authorization(js, dl), cmddl.RequireScope(auth.ScopeUsersWrite)).Patch("/{UserUUID}", ac.UpdateUser)
+				r.With(cmddl.Authorization(js, dl), cmddl.RequireScope(auth.ScopeUsersWrite)).Delete("/{UserUUID}", ac.DeleteUser)
+				r.With(cmddl.Authorization(js, dl), cmddl.RequireScope(auth.ScopeUsersRead), cmddl.RequirePermission(auth.PermissionUsersRead)).Get("/{UserUUID}/lockout", ac.GetAccountLockout)
+				r.With(cmddl.Authorization(js, dl), cmddl.RequireScope(auth.ScopeUsersWrite), cmddl.RequirePermission(auth.PermissionUsersUpdate)).Delete("/{UserUUID}/lockout", ac.DeleteAccountLockout)
+				r.Route("/{UserUUID}/sessions", func(r chi.Router) {
+					r.Use(cmddl.Authorization(js, dl), cmddl.RequireScope(auth.ScopeSessionsManage), cmddl.RequirePermission(auth.PermissionSessionsManage))
+					r.Get("/", ac.GetUserSessions)
+					r.Delete("/", ac.RevokeUserSessions)
+					r.With(cmddl.ValidateUUIDParam("SessionUUID")).Delete("/{SessionUUID}", ac.RevokeUserSession)
+				})
 			})
 		})
 	})
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
-	}
+	port := getEnv("PORT", "8080")
 	server := &http.Server{
-		Addr:    ":" + port,
-		Handler: r,
+		Addr:      ":" + port,
+		Handler:   r,
+		TLSConfig: newTLSConfig(),
+	}
+
+	if certFile := os.Getenv("TLS_CERT_FILE"); certFile != "" {
+		log.Printf("Starting HTTPS server at https://localhost:%s", port)
+		err = server.ListenAndServeTLS(certFile, os.Getenv("TLS_KEY_FILE"))
+	} else {
+		log.Printf("Starting HTTP server at http://localhost:%s", port)
+		err = server.ListenAndServe()
+	}
+	if err != nil {
+		log.Panic(err)
+	}
+
+}
+
+func getEnv(key string, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// Bootstraps administrators from comma separated emails of already registered users
+// Admins are exactly the users listed, so removing an email from ADMIN_EMAILS takes the role away on next start
+func syncAdminRole(as *postgres.AuthService, emails string) {
+	admins := make([]auth.UUID, 0)
+	for _, email := range strings.Split(emails, ",") {
+		email = strings.TrimSpace(email)
+		if email == "" {
+			continue
+		}
+
+		user, err := as.GetUserByEmail(email)
+		if err != nil {
+			if !errors.Is(err, sql.ErrNoRows) {
+				log.Panic(err)
+			}
+			log.Printf("Skipping admin %s: %v", email, err)
+			continue
+		}
+		if err = as.AddUserRole(user.UUID, auth.RoleAdmin); err != nil {
+			log.Panic(err)
+		}
+		admins = append(admins, user.UUID)
+		log.Printf("Granted %s role to %s", auth.RoleAdmin, email)
+	}
+
+	removed, err := as.RemoveRoleFromOtherUsers(auth.RoleAdmin, admins)
+	if err != nil {
+		log.Panic(err)
+	}
+	for _, userUUID := range removed {
+		log.Printf("Removed %s role from %s", auth.RoleAdmin, userUUID)
+	}
+}
+
+// Doesn't restrict users with unverified email, unless EMAIL_VERIFICATION_POLICY is set
+func newAuthControllerConfig() chi.Config {
+	policy := auth.EmailVerificationPolicy(os.Getenv("EMAIL_VERIFICATION_POLICY"))
+	switch policy {
+	case "":
+		policy = auth.EmailVerificationPolicyNone
+	case auth.EmailVerificationPolicyNone, auth.EmailVerificationPolicyRestrict, auth.EmailVerificationPolicyBlock:
+	default:
+		log.Panic(fmt.Errorf("error creating auth controller: EMAIL_VERIFICATION_POLICY must be one of none, restrict or block"))
+	}
+
+	return chi.Config{
+		EmailVerificationPolicy: policy,
+		EmailVerificationURL:    os.Getenv("EMAIL_VERIFICATION_URL"),
+		PasswordResetURL:        os.Getenv("PASSWORD_RESET_URL"),
+		MagicLinkURL:            os.Getenv("MAGIC_LINK_URL"),
+		AccountUnlockURL:        os.Getenv("ACCOUNT_UNLOCK_URL"),
+	}
+}
+
+// Keeps revoked access tokens in the database, so that all instances of the service reject them,
+// unless ACCESS_TOKEN_DENYLIST=memory is set for a single instance
+func newAccessTokenDenylist(db *sql.DB) auth.AccessTokenDenylist {
+	switch os.Getenv("ACCESS_TOKEN_DENYLIST") {
+	case "", "postgres":
+		return postgres.NewAccessTokenDenylist(db)
+	case "memory":
+		return memory.NewAccessTokenDenylist()
+	default:
+		log.Panic(fmt.Errorf("error creating access token denylist: ACCESS_TOKEN_DENYLIST must be either postgres or memory"))
+		return nil
+	}
+}
+
+// Asks clients for certificates signed by the client CA if one is configured, so that service clients
+// can authenticate with them instead of a secret. Clients without a certificate are still served
+func newTLSConfig() *tls.Config {
+	caFile := os.Getenv("TLS_CLIENT_CA_FILE")
+	if caFile == "" {
+		return nil
+	}
+	if os.Getenv("TLS_CERT_FILE") == "" {
+		log.Panic(fmt.Errorf("error creating tls config: TLS_CLIENT_CA_FILE requires TLS_CERT_FILE and TLS_KEY_FILE"))
 	}
-	log.Printf("Starting HTTP server at http://localhost:%s", port)
 
-	err = server.ListenAndServe()
+	caPEM, err := os.ReadFile(caFile)
+	if err != nil {
+		log.Panic(fmt.Errorf("error reading client ca: %w", err))
+	}
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM(caPEM) {
+		log.Panic(fmt.Errorf("error reading client ca: no certificates found in %s", caFile))
+	}
+
+	return &tls.Config{
+		ClientAuth: tls.VerifyClientCertIfGiven,
+		ClientCAs:  clientCAs,
+	}
+}
+
+// Without trusted proxies the address of the peer is used, as any forwarding header could be made up by the client
+func newClientIPResolver() *clientip.Resolver {
+	header, err := clientip.ParseHeader(getEnv("CLIENT_IP_HEADER", string(clientip.HeaderXForwardedFor)))
+	if err != nil {
+		log.Panic(fmt.Errorf("error creating client ip resolver: %w", err))
+	}
+	trustedProxies, err := clientip.ParseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		log.Panic(fmt.Errorf("error creating client ip resolver: %w", err))
+	}
+
+	return clientip.NewResolver(header, trustedProxies)
+}
+
+// Notifies users of any ip change, unless IP_CHANGE_POLICY is set
+func newIPChangePolicy() *policy.IPChangePolicy {
+	rulesStr := os.Getenv("IP_CHANGE_POLICY")
+	if rulesStr == "" {
+		return policy.NewIPChangePolicy(policy.DefaultIPChangeRules)
+	}
+
+	rules, err := policy.ParseIPChangeRules(rulesStr)
+	if err != nil {
+		log.Panic(fmt.Errorf("error creating ip change policy: %w", err))
+	}
+	return policy.NewIPChangePolicy(rules)
+}
+
+// Counters have to be shared by all instances of the service, unless there is only one
+func newRateLimitStore(db *sql.DB) auth.RateLimitStore {
+	switch os.Getenv("RATE_LIMIT_STORE") {
+	case "", "postgres":
+		return postgres.NewRateLimitStore(db)
+	case "memory":
+		return memory.NewRateLimitStore()
+	default:
+		log.Panic(fmt.Errorf("error creating rate limit store: RATE_LIMIT_STORE must be either postgres or memory"))
+		return nil
+	}
+}
+
+// Signs access tokens with keys from the keys directory or the asymmetric private key if one is configured,
+// falling back to HMAC-SHA512 with the shared secret
+func newJWTService(us *uuid.UUIDService) *jwt.JWTService {
+	opts := []jwt.Option{jwt.WithIssuer(os.Getenv("JWT_ISSUER"))}
+	if audience := os.Getenv("JWT_AUDIENCE"); audience != "" {
+		opts = append(opts, jwt.WithAudience(strings.Split(audience, ",")...))
+	}
+	if expectedAudience := os.Getenv("JWT_EXPECTED_AUDIENCE"); expectedAudience != "" {
+		opts = append(opts, jwt.WithExpectedAudience(expectedAudience))
+	}
+
+	if keysDir := os.Getenv("JWT_KEYS_DIR"); keysDir != "" {
+		active, retired, err := jwt.LoadKeyDir(keysDir)
+		if err != nil {
+			log.Panic(err)
+		}
+		keys := jwt.NewKeyRing(active, retired...)
+		logKeyRing(keys)
+		go reloadKeyRingOnSignal(keys, keysDir)
+
+		return jwt.NewJWTServiceWithKeyRing(keys, us, opts...)
+	}
+
+	privateKey := os.Getenv("JWT_PRIVATE_KEY")
+	privateKeyFile := os.Getenv("JWT_PRIVATE_KEY_FILE")
+	if privateKey == "" && privateKeyFile == "" {
+		return jwt.NewJWTService(os.Getenv("JWT_ACCESS_SECRET"), us, opts...)
+	}
+
+	key, err := jwt.LoadPrivateKey(privateKey, privateKeyFile)
 	if err != nil {
 		log.Panic(err)
 	}
+	log.Printf("Signing access tokens with %s key %s", key.Method.Alg(), key.Kid)
+
+	return jwt.NewJWTServiceWithKey(key, us, opts...)
+}
+
+// Rotates keys without a restart: on SIGHUP keys directory is read again and,
+// if it is valid, replaces all the keys at once
+func reloadKeyRingOnSignal(keys *jwt.KeyRing, keysDir string) {
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
 
+	for range sighup {
+		active, retired, err := jwt.LoadKeyDir(keysDir)
+		if err != nil {
+			log.Printf("Keeping current keys, failed to reload them: %v", err)
+			continue
+		}
+		keys.Replace(active, retired...)
+		logKeyRing(keys)
+	}
+}
+
+func logKeyRing(keys *jwt.KeyRing) {
+	for i, key := range keys.Keys() {
+		state := "retired"
+		if i == 0 {
+			state = "active"
+		}
+		log.Printf("Loaded %s %s key %s", state, key.Method.Alg(), key.Kid)
+	}
 }
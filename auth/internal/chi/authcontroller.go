@@ -1,12 +1,16 @@
 package chi
 
 import (
+	"context"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"net"
+	"log"
 	"net/http"
 	"net/netip"
+	"slices"
+	"strings"
 	"time"
 
 	auth "github.com/medods-technical-assessment"
@@ -14,7 +18,12 @@ import (
 	"github.com/medods-technical-assessment/pkg/utils"
 )
 
-const accessTokenExpireTime = 5 * time.Minute
+const (
+	accessTokenExpireTime = 5 * time.Minute
+	maxUserAgentLength    = 512
+)
+
+var errInvalidCredentials = errors.New("invalid email or password")
 
 type AuthController struct {
 	service           auth.AuthService
@@ -23,9 +32,31 @@ type AuthController struct {
 	uuidService       auth.UUIDService
 	jwtService        auth.JWTService
 	mailService       auth.MailService
+	denylist          auth.AccessTokenDenylist
+	policyService     auth.PolicyService
+	totpService       auth.TOTPService
+	webAuthnService   auth.WebAuthnService
+	loginLimiter      auth.LoginLimiter
+	emailLimiter      auth.LoginLimiter
+	ipResolver        auth.ClientIPResolver
+	ipChangePolicy    auth.IPChangePolicy
+	config            Config
+	// Compared against on login with an unknown email, so that it takes as long as with a wrong password
+	dummyPasswordHash string
+}
+
+// Config holds settings of the controller which differ between deployments
+type Config struct {
+	EmailVerificationPolicy auth.EmailVerificationPolicy
+	// Pages links in emails lead to, with token added as `token` query param. The pages are expected
+	// to call the corresponding endpoint with the token. If empty, bare token is sent instead of a link
+	EmailVerificationURL string
+	PasswordResetURL     string
+	MagicLinkURL         string
+	AccountUnlockURL     string
 }
 
-func NewAuthController(service auth.AuthService, validationService auth.ValidationService, cryptoService auth.CryptoService, uuidService auth.UUIDService, jwtService auth.JWTService, mailService auth.MailService) *AuthController {
+func NewAuthController(service auth.AuthService, validationService auth.ValidationService, cryptoService auth.CryptoService, uuidService auth.UUIDService, jwtService auth.JWTService, mailService auth.MailService, denylist auth.AccessTokenDenylist, policyService auth.PolicyService, totpService auth.TOTPService, webAuthnService auth.WebAuthnService, loginLimiter auth.LoginLimiter, emailLimiter auth.LoginLimiter, ipResolver auth.ClientIPResolver, ipChangePolicy auth.IPChangePolicy, config Config) *AuthController {
 	return &AuthController{
 		service:           service,
 		validationService: validationService,
@@ -33,13 +64,48 @@ func NewAuthController(service auth.AuthService, validationService auth.Validati
 		uuidService:       uuidService,
 		jwtService:        jwtService,
 		mailService:       mailService,
+		denylist:          denylist,
+		policyService:     policyService,
+		totpService:       totpService,
+		webAuthnService:   webAuthnService,
+		loginLimiter:      loginLimiter,
+		emailLimiter:      emailLimiter,
+		ipResolver:        ipResolver,
+		ipChangePolicy:    ipChangePolicy,
+		config:            config,
+		dummyPasswordHash: cryptoService.HashPassword(uuidService.New().String()),
 	}
 }
 
 // ref: https://stackoverflow.com/a/68100270
-type CtxUserUUIDKey struct{}
+// Keyed by the name of the validated URL param, e.g. `CtxUUIDParamKey{"UserUUID"}`
+type CtxUUIDParamKey struct {
+	Param string
+}
+
+type CtxPrincipalKey struct{}
+
+// Returns identity of the caller, put into the context by the Authorization middleware
+func GetPrincipal(ctx context.Context) (*auth.Principal, error) {
+	principal, ok := ctx.Value(CtxPrincipalKey{}).(*auth.Principal)
+	if !ok {
+		return nil, fmt.Errorf("failed to get principal from context")
+	}
+
+	return principal, nil
+}
+
+type CtxClientKey struct{}
 
-type CtxAccessTokenKey struct{}
+// Returns the service client making the request, put into the context by the ClientAuthentication middleware
+func GetClient(ctx context.Context) (*auth.Client, error) {
+	client, ok := ctx.Value(CtxClientKey{}).(*auth.Client)
+	if !ok {
+		return nil, fmt.Errorf("failed to get client from context")
+	}
+
+	return client, nil
+}
 
 func (c *AuthController) GetUser(w http.ResponseWriter, r *http.Request) {
 
@@ -48,6 +114,9 @@ func (c *AuthController) GetUser(w http.ResponseWriter, r *http.Request) {
 		InternalErrorHandler(w, err)
 		return
 	}
+	if !c.authorize(w, r, auth.ActionUsersRead, &auth.Resource{OwnerUUID: userUUID}) {
+		return
+	}
 	user, err := c.service.GetUser(userUUID)
 
 	if err != nil {
@@ -113,6 +182,8 @@ func (c *AuthController) CreateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	c.trySendVerificationEmail(r, createdUser)
+
 	if err = c.writeResponse(respParams{w: w, code: http.StatusCreated, json: createdUser}); err != nil {
 		InternalErrorHandler(w, err)
 		return
@@ -125,6 +196,9 @@ func (c *AuthController) UpdateUser(w http.ResponseWriter, r *http.Request) {
 		InternalErrorHandler(w, err)
 		return
 	}
+	if !c.authorize(w, r, auth.ActionUsersUpdate, &auth.Resource{OwnerUUID: userUUID}) {
+		return
+	}
 	user, err := c.service.GetUser(userUUID)
 
 	if err != nil {
@@ -147,11 +221,17 @@ func (c *AuthController) UpdateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if userInput.Email != "" {
-		user.Email = userInput.Email
-	}
+	// Otherwise a stolen access token would be enough to take over the account
 	if userInput.Password != "" {
-		user.Password = c.cryptoService.HashPassword(userInput.Password)
+		BadRequestErrorHandler(w, fmt.Errorf("password can't be updated, use POST /api/v1/auth/password/change instead"))
+		return
+	}
+
+	// New email has to be verified again
+	isEmailChanged := userInput.Email != "" && userInput.Email != user.Email
+	if isEmailChanged {
+		user.Email = userInput.Email
+		user.EmailVerified = false
 	}
 
 	updatedUser, err := c.service.UpdateUser(user)
@@ -164,6 +244,16 @@ func (c *AuthController) UpdateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if isEmailChanged {
+		// Links sent to the previous email would otherwise verify the new one when redeemed
+		for _, purpose := range []auth.ActionPurpose{auth.ActionPurposeMagicLink, auth.ActionPurposeResetPassword} {
+			if err = c.service.DeleteOneTimeTokensByUser(updatedUser.UUID, purpose); err != nil {
+				log.Print(err)
+			}
+		}
+		c.trySendVerificationEmail(r, updatedUser)
+	}
+
 	if err = c.writeResponse(respParams{w: w, code: http.StatusOK, json: updatedUser}); err != nil {
 		InternalErrorHandler(w, err)
 		return
@@ -176,6 +266,19 @@ func (c *AuthController) DeleteUser(w http.ResponseWriter, r *http.Request) {
 		InternalErrorHandler(w, err)
 		return
 	}
+	if !c.authorize(w, r, auth.ActionUsersDelete, &auth.Resource{OwnerUUID: userUUID}) {
+		return
+	}
+
+	// Refresh tokens are deleted along with the user, so access tokens issued with them are denied beforehand
+	if err = c.service.RevokeRefreshTokensByUser(userUUID); err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+	if err = c.denyAllSessionsAccessTokens(userUUID); err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
 	err = c.service.DeleteUser(userUUID)
 
 	if err != nil {
@@ -213,32 +316,46 @@ func (c *AuthController) Register(w http.ResponseWriter, r *http.Request) {
 		Password: c.cryptoService.HashPassword(userInput.Password),
 	}
 
-	refreshPayload, accessPayload := c.createPayloads(r)
-
-	accessTokenStr, refreshTokenStr, err := c.jwtService.GenerateTokens(refreshPayload, accessPayload)
+	_, err := c.service.CreateUser(user)
 	if err != nil {
+		if errors.Is(err, common.ErrDuplicateEmail) {
+			ConflictErrorHandler(w, err)
+			return
+		}
 		InternalErrorHandler(w, err)
 		return
 	}
 
-	_, err = c.service.CreateUser(user)
+	c.trySendVerificationEmail(r, user)
+
+	scope := strings.Join(auth.Scopes, " ")
+	accessScope, err := c.applyEmailVerificationPolicy(user, scope)
 	if err != nil {
-		if errors.Is(err, common.ErrDuplicateEmail) {
-			ConflictErrorHandler(w, err)
-			return
+		// Account is created without a session, since unverified users can't log in
+		if err = c.writeResponse(respParams{w: w, code: http.StatusCreated, json: user.ToPublic()}); err != nil {
+			InternalErrorHandler(w, err)
 		}
-		InternalErrorHandler(w, err)
 		return
 	}
 
-	refreshToken := c.makeRefreshToken(refreshTokenStr, accessPayload.Jti, user.UUID, accessPayload.Iat)
+	// Each login starts a new session, i.e. a new refresh token family
+	refreshPayload, accessPayload, err := c.createPayloads(r, user.UUID, c.uuidService.New(), accessScope)
+	if err != nil {
+		c.service.DeleteUser(user.UUID)
+		InternalErrorHandler(w, err)
+		return
+	}
 
-	err = c.service.RevokeRefreshTokensByUser(user.UUID)
+	accessTokenStr, refreshTokenStr, err := c.jwtService.GenerateTokens(refreshPayload, accessPayload)
 	if err != nil {
+		c.service.DeleteUser(user.UUID)
 		InternalErrorHandler(w, err)
 		return
 	}
 
+	refreshToken := c.makeRefreshToken(r, refreshPayload, accessPayload)
+	refreshToken.Scope = scope
+
 	err = c.service.AddRefreshToken(refreshToken)
 	if err != nil {
 		c.service.DeleteUser(user.UUID)
@@ -267,22 +384,51 @@ func (c *AuthController) Login(w http.ResponseWriter, r *http.Request) {
 		BadRequestErrorHandler(w, err)
 		return
 	}
+
+	// Reserved before the password is checked, so that the response doesn't tell whether a guess was right
+	if !c.reserveLoginAttempt(w, r, loginInput.Email) {
+		return
+	}
+
 	user, err := c.service.GetUserByEmail(loginInput.Email)
 
 	if err != nil {
-		NotFoundErrorHandler(w, err)
+		// Unknown emails get the same response after the same time as wrong passwords, so that
+		// login can't be used to find out registered emails
+		c.cryptoService.ComparePasswords(c.dummyPasswordHash, loginInput.Password)
+		log.Print(err)
+		ForbiddenErrorHandler(w, errInvalidCredentials)
+		return
+	}
+
+	if !c.checkAccountLock(w, user) {
 		return
 	}
 
 	if err = c.cryptoService.ComparePasswords(user.Password, loginInput.Password); err != nil {
-		ForbiddenErrorHandler(w, err)
+		c.countFailedLogin(r, user)
+		ForbiddenErrorHandler(w, errInvalidCredentials)
+		return
+	}
+	c.releaseLoginAttempt(r, loginInput.Email)
+
+	scope, err := c.narrowScope(loginInput.Scope, auth.Scopes)
+	if err != nil {
+		BadRequestErrorHandler(w, err)
 		return
 	}
 
-	c.handleSuccessfulAuth(w, r, user)
+	c.handleFirstFactorAuth(w, r, user, scope)
 }
 
+// Issues tokens for the user without their credentials, so only service clients with the
+// login_by_uuid grant may call it. Every issuance is recorded with the client which requested it
 func (c *AuthController) LoginByUUID(w http.ResponseWriter, r *http.Request) {
+	client, err := GetClient(r.Context())
+	if err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
 
 	userUUID, err := c.getUserUUIDFromContext(r)
 	if err != nil {
@@ -296,7 +442,9 @@ func (c *AuthController) LoginByUUID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	c.handleSuccessfulAuth(w, r, user)
+	c.recordSecurityEvent(r, user.UUID, auth.SecurityEventLoginByUUID, fmt.Sprintf("client %s logged in as the user by uuid", client.ID))
+
+	c.handleFirstFactorAuth(w, r, user, strings.Join(auth.Scopes, " "))
 }
 
 func (c *AuthController) Refresh(w http.ResponseWriter, r *http.Request) {
@@ -304,7 +452,7 @@ func (c *AuthController) Refresh(w http.ResponseWriter, r *http.Request) {
 	decoder := json.NewDecoder(r.Body)
 	decoder.DisallowUnknownFields()
 
-	var refreshInput auth.Tokens
+	var refreshInput auth.RefreshDto
 	if err := decoder.Decode(&refreshInput); err != nil {
 		BadRequestErrorHandler(w, err)
 		return
@@ -316,7 +464,7 @@ func (c *AuthController) Refresh(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	refreshToken, err := c.service.GetActiveRefreshToken(accessPayload.Jti)
+	refreshToken, err := c.service.GetRefreshToken(accessPayload.Jti)
 	if err != nil {
 		ForbiddenErrorHandler(w, err)
 		return
@@ -328,45 +476,78 @@ func (c *AuthController) Refresh(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = c.cryptoService.ComparePasswords(refreshToken.HashedToken, refreshInput.RefreshToken)
+	refreshPayload, err := c.jwtService.GetRefreshTokenPayload(refreshInput.RefreshToken)
 	if err != nil {
 		ForbiddenErrorHandler(w, err)
 		return
 	}
 
-	refreshPayload, err := c.jwtService.GetRefreshTokenPayload(refreshInput.RefreshToken)
+	err = c.compareRefreshTokenSecret(refreshToken, refreshPayload)
 	if err != nil {
 		ForbiddenErrorHandler(w, err)
 		return
 	}
 
+	// Checked before reuse detection, so that a token rotated on password change doesn't look stolen when presented
+	if c.isIssuedBeforePasswordChange(user, accessPayload.Iat) {
+		ForbiddenErrorHandler(w, errIssuedBeforePasswordChange)
+		return
+	}
+
+	if !refreshToken.Active {
+		c.handleInactiveRefreshToken(w, r, user, refreshToken)
+		return
+	}
+
 	if refreshPayload.Jti != accessPayload.Jti {
 		ForbiddenErrorHandler(w, fmt.Errorf("jti in accessToken and refreshToken do not match"))
 		return
 	}
 
-	newRefreshPayload, newAccessPayload := c.createPayloads(r)
-
-	if accessPayload.IP != newAccessPayload.IP {
-		c.mailService.Send(user.Email, "New login", fmt.Sprintf(`We noticed you logged in from a new ip address %s. If this was you, there's nothing for you to do right now.`, newAccessPayload.IP))
+	// Session's scopes can't be widened, but access token may be restricted to some of them
+	grantedScopes := strings.Fields(refreshToken.Scope)
+	if len(grantedScopes) == 0 {
+		grantedScopes = auth.Scopes
+	}
+	scope, err := c.narrowScope(refreshInput.Scope, grantedScopes)
+	if err != nil {
+		BadRequestErrorHandler(w, err)
+		return
+	}
+	scope, err = c.applyEmailVerificationPolicy(user, scope)
+	if err != nil {
+		c.handleEmailNotVerified(w, r, user, err)
+		return
 	}
 
-	newAccessTokenStr, newRefreshTokenStr, err := c.jwtService.GenerateTokens(newRefreshPayload, newAccessPayload)
+	newRefreshPayload, newAccessPayload, err := c.createPayloads(r, user.UUID, refreshToken.FamilyUUID, scope)
 	if err != nil {
 		InternalErrorHandler(w, err)
 		return
 	}
 
-	newRefreshToken := c.makeRefreshToken(newRefreshTokenStr, newAccessPayload.Jti, user.UUID, accessPayload.Iat)
+	if !c.checkIPChange(w, r, user, refreshToken, refreshPayload.IP, newAccessPayload.Roles) {
+		return
+	}
 
-	err = c.service.RevokeRefreshTokensByUser(user.UUID)
+	newAccessTokenStr, newRefreshTokenStr, err := c.jwtService.GenerateTokens(newRefreshPayload, newAccessPayload)
 	if err != nil {
 		InternalErrorHandler(w, err)
 		return
 	}
 
-	err = c.service.AddRefreshToken(newRefreshToken)
+	newRefreshToken := c.makeRefreshToken(r, newRefreshPayload, newAccessPayload)
+	newRefreshToken.ParentUUID = &refreshToken.UUID
+	newRefreshToken.Scope = refreshToken.Scope
+
+	// Rotates only the token presented, leaving user's other sessions intact. If it has been rotated
+	// concurrently in the meantime, one of the two requests is replaying it
+	err = c.service.RotateRefreshToken(refreshToken.UUID, newRefreshToken)
 	if err != nil {
+		if errors.Is(err, common.ErrRefreshTokenNotActive) {
+			c.handleInactiveRefreshToken(w, r, user, refreshToken)
+			return
+		}
 		InternalErrorHandler(w, err)
 		return
 	}
@@ -382,27 +563,52 @@ func (c *AuthController) Refresh(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (c *AuthController) GetMe(w http.ResponseWriter, r *http.Request) {
-	accessTokenStr, err := c.getAccessTokenFromContext(r)
-	if err != nil {
+// Rejects the Refresh operation for a genuine, but no longer active refresh token.
+// If the token has already been rotated, someone is replaying it: either the legitimate
+// client or an attacker holds a stolen copy, and since there is no telling which one,
+// the whole session is revoked
+//
+// ref: https://datatracker.ietf.org/doc/html/draft-ietf-oauth-security-topics#section-4.14.2
+func (c *AuthController) handleInactiveRefreshToken(w http.ResponseWriter, r *http.Request, user *auth.User, refreshToken *auth.RefreshToken) {
+	childRefreshToken, err := c.service.GetRefreshTokenByParent(refreshToken.UUID)
+	if err != nil {
+		if errors.Is(err, common.ErrRefreshTokenNotFound) {
+			ForbiddenErrorHandler(w, fmt.Errorf("refresh token is revoked"))
+			return
+		}
 		InternalErrorHandler(w, err)
 		return
 	}
-	accessPayload, err := c.jwtService.GetAccessTokenPayload(accessTokenStr)
-	if err != nil {
+
+	ipStr, _ := c.getIp(r)
+	c.recordSecurityEvent(r, user.UUID, auth.SecurityEventRefreshTokenReuse, fmt.Sprintf("refresh token %s of session %s was replayed after being rotated into %s", refreshToken.UUID, refreshToken.FamilyUUID, childRefreshToken.UUID))
+
+	err = c.service.RevokeRefreshTokenFamily(user.UUID, refreshToken.FamilyUUID)
+	if err != nil && !errors.Is(err, common.ErrRefreshTokenFamilyNotFound) {
 		InternalErrorHandler(w, err)
 		return
 	}
+	// Only alerts the user once, when the session is actually revoked
+	if err == nil {
+		if err = c.denySessionAccessTokens(user.UUID, refreshToken.FamilyUUID); err != nil {
+			log.Print(err)
+		}
+		c.mailService.Send(user.Email, "Suspicious activity", fmt.Sprintf(`An already used refresh token of one of your sessions was presented again from ip address %s. It might have been stolen, so we have logged this session out. If you don't recognize this activity, please change your password.`, ipStr))
+	}
+
+	ForbiddenErrorHandler(w, fmt.Errorf("refresh token reuse detected: session has been revoked"))
+}
 
-	refreshToken, err := c.service.GetActiveRefreshToken(accessPayload.Jti)
+func (c *AuthController) GetMe(w http.ResponseWriter, r *http.Request) {
+	principal, err := GetPrincipal(r.Context())
 	if err != nil {
-		ForbiddenErrorHandler(w, err)
+		InternalErrorHandler(w, err)
 		return
 	}
 
-	user, err := c.service.GetUser(refreshToken.UserUUID)
+	user, err := c.service.GetUser(principal.UserUUID)
 	if err != nil {
-		InternalErrorHandler(w, err)
+		NotFoundErrorHandler(w, err)
 		return
 	}
 
@@ -412,51 +618,194 @@ func (c *AuthController) GetMe(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// Returns string with either IPv4 or IPv6
-func (c *AuthController) getIp(r *http.Request) (string, netip.Addr) {
-	ipStr, _, err := net.SplitHostPort(r.RemoteAddr)
+// Publishes public keys which verify access tokens, so that other services don't need the signing key
+func (c *AuthController) GetJWKS(w http.ResponseWriter, r *http.Request) {
+	jwks := c.jwtService.GetJWKS()
+
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	if err := c.writeResponse(respParams{w: w, code: http.StatusOK, json: jwks}); err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+}
+
+// Returns space-delimited scopes the token is issued with: either requested ones, if all of them
+// are granted, or all granted scopes when none are requested
+func (c *AuthController) narrowScope(requested string, granted []string) (string, error) {
+	requestedScopes := strings.Fields(requested)
+	if len(requestedScopes) == 0 {
+		return strings.Join(granted, " "), nil
+	}
+
+	for _, scope := range requestedScopes {
+		if !slices.Contains(granted, scope) {
+			return "", fmt.Errorf("invalid scope: %s is not granted", scope)
+		}
+	}
+
+	// Keeps canonical order and drops duplicates
+	scopes := slices.DeleteFunc(slices.Clone(granted), func(scope string) bool {
+		return !slices.Contains(requestedScopes, scope)
+	})
+
+	return strings.Join(scopes, " "), nil
+}
 
+// Checks whether the caller may perform the action on the resource, responding with 403 Forbidden if not.
+// Requires the Authorization middleware
+func (c *AuthController) authorize(w http.ResponseWriter, r *http.Request, action auth.Action, resource *auth.Resource) bool {
+	principal, err := GetPrincipal(r.Context())
 	if err != nil {
+		InternalErrorHandler(w, err)
+		return false
+	}
+
+	if err = c.policyService.Authorize(principal, action, resource); err != nil {
+		if errors.Is(err, common.ErrAccessDenied) {
+			ForbiddenErrorHandler(w, err)
+			return false
+		}
+		InternalErrorHandler(w, err)
+		return false
+	}
+
+	return true
+}
+
+// Returns string with either IPv4 or IPv6 of the client, rather than of the proxy in front of the service
+func (c *AuthController) getIp(r *http.Request) (string, netip.Addr) {
+	ip := c.ipResolver.ClientIP(r)
+	if !ip.IsValid() {
 		return "", netip.Addr{}
 	}
 
-	ip, err := netip.ParseAddr(ipStr)
+	return ip.String(), ip
+}
+
+// Returns User-Agent header truncated to a size which is reasonable to store
+func (c *AuthController) getUserAgent(r *http.Request) string {
+	userAgent := r.UserAgent()
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = strings.ToValidUTF8(userAgent[:maxUserAgentLength], "")
+	}
+	return userAgent
+}
+
+// Failing to record an event is logged, but doesn't fail the request
+func (c *AuthController) recordSecurityEvent(r *http.Request, userUUID auth.UUID, eventType auth.SecurityEventType, details string) {
+	ipStr, _ := c.getIp(r)
+	event := &auth.SecurityEvent{
+		UUID:      c.uuidService.New(),
+		UserUUID:  userUUID,
+		Type:      eventType,
+		IP:        ipStr,
+		UserAgent: c.getUserAgent(r),
+		Details:   details,
+		CreatedAt: time.Now(),
+	}
+
+	if err := c.service.AddSecurityEvent(event); err != nil {
+		log.Print(err)
+	}
+}
+
+// Denylists access tokens of the revoked session which might not have expired yet,
+// so that revocation takes effect immediately rather than once they expire
+func (c *AuthController) denySessionAccessTokens(userUUID auth.UUID, sessionUUID auth.UUID) error {
+	return c.denyAccessTokens(userUUID, func(refreshToken *auth.RefreshToken) bool {
+		return refreshToken.FamilyUUID == sessionUUID
+	})
+}
+
+func (c *AuthController) denyOtherSessionsAccessTokens(userUUID auth.UUID, sessionUUID auth.UUID) error {
+	return c.denyAccessTokens(userUUID, func(refreshToken *auth.RefreshToken) bool {
+		return refreshToken.FamilyUUID != sessionUUID
+	})
+}
+
+func (c *AuthController) denyAllSessionsAccessTokens(userUUID auth.UUID) error {
+	return c.denyAccessTokens(userUUID, func(refreshToken *auth.RefreshToken) bool {
+		return true
+	})
+}
+
+// Access token shares jti with the refresh token issued along with it, so tokens issued
+// within the lifetime of an access token identify all access tokens which are still valid
+func (c *AuthController) denyAccessTokens(userUUID auth.UUID, isRevoked func(refreshToken *auth.RefreshToken) bool) error {
+	refreshTokens, err := c.service.GetRefreshTokensByUserSince(userUUID, time.Now().Add(-accessTokenExpireTime))
 	if err != nil {
-		return "", netip.Addr{}
+		return err
 	}
-	return ipStr, ip
+
+	for _, refreshToken := range refreshTokens {
+		if !isRevoked(refreshToken) {
+			continue
+		}
+		if err = c.denylist.Add(refreshToken.UUID, refreshToken.CreatedAt.Add(accessTokenExpireTime)); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
-func (c *AuthController) createPayloads(r *http.Request) (*auth.RefreshPayload, *auth.AccessPayload) {
+// Access token carries user's roles and permissions, so changes to them take effect on the next Refresh operation
+func (c *AuthController) createPayloads(r *http.Request, userUUID auth.UUID, sessionUUID auth.UUID, scope string) (*auth.RefreshPayload, *auth.AccessPayload, error) {
+	roles, err := c.service.GetRolesByUser(userUUID)
+	if err != nil {
+		return nil, nil, err
+	}
+	permissions, err := c.service.GetPermissionsByUser(userUUID)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	issuedAt := time.Now()
 	ipStr, ip := c.getIp(r)
 	refreshPayload := &auth.RefreshPayload{Jti: c.uuidService.New(), IP: ip}
-	accessPayload := &auth.AccessPayload{Jti: refreshPayload.Jti, IP: ipStr, Iat: issuedAt.Unix(), Exp: issuedAt.Add(accessTokenExpireTime).Unix()}
+	accessPayload := &auth.AccessPayload{Jti: refreshPayload.Jti, Sub: userUUID, Sid: sessionUUID, IP: ipStr, Iat: issuedAt.Unix(), Nbf: issuedAt.Unix(), Exp: issuedAt.Add(accessTokenExpireTime).Unix(), Scope: scope, Roles: roles, Permissions: permissions}
 
-	return refreshPayload, accessPayload
+	return refreshPayload, accessPayload, nil
 }
 
-func (c *AuthController) handleSuccessfulAuth(w http.ResponseWriter, r *http.Request, user *auth.User) {
-	refreshPayload, accessPayload := c.createPayloads(r)
+func (c *AuthController) handleSuccessfulAuth(w http.ResponseWriter, r *http.Request, user *auth.User, scope string) {
+	accessScope, err := c.applyEmailVerificationPolicy(user, scope)
+	if err != nil {
+		c.handleEmailNotVerified(w, r, user, err)
+		return
+	}
 
-	accessTokenStr, refreshTokenStr, err := c.jwtService.GenerateTokens(refreshPayload, accessPayload)
+	// Each login starts a new session, i.e. a new refresh token family
+	refreshPayload, accessPayload, err := c.createPayloads(r, user.UUID, c.uuidService.New(), accessScope)
 	if err != nil {
 		InternalErrorHandler(w, err)
 		return
 	}
 
-	refreshToken := c.makeRefreshToken(refreshTokenStr, accessPayload.Jti, user.UUID, accessPayload.Iat)
-
-	if err = c.service.RevokeRefreshTokensByUser(user.UUID); err != nil {
+	accessTokenStr, refreshTokenStr, err := c.jwtService.GenerateTokens(refreshPayload, accessPayload)
+	if err != nil {
 		InternalErrorHandler(w, err)
 		return
 	}
 
+	refreshToken := c.makeRefreshToken(r, refreshPayload, accessPayload)
+	refreshToken.Scope = scope
+
 	if err = c.service.AddRefreshToken(refreshToken); err != nil {
 		InternalErrorHandler(w, err)
 		return
 	}
 
+	// Failures are only forgotten once all factors are passed, so that a known password doesn't
+	// allow guessing the second factor indefinitely
+	if err = c.loginLimiter.RecordSuccess(user.Email); err != nil {
+		log.Print(err)
+	}
+	// Reset whatever user has been read with, as failures may have been recorded by concurrent requests since
+	if err = c.service.UnlockUser(user.UUID); err != nil {
+		log.Print(err)
+	}
+
 	tokens := &auth.Tokens{
 		AccessToken:  accessTokenStr,
 		RefreshToken: refreshTokenStr,
@@ -468,34 +817,43 @@ func (c *AuthController) handleSuccessfulAuth(w http.ResponseWriter, r *http.Req
 	}
 }
 
-func (c *AuthController) makeRefreshToken(refreshTokenStr string, uuid auth.UUID, userUUID auth.UUID, Iat int64) *auth.RefreshToken {
+// Jti is left out of the hash, as the whole token may exceed bcrypt's input limit
+func (c *AuthController) makeRefreshToken(r *http.Request, refreshPayload *auth.RefreshPayload, accessPayload *auth.AccessPayload) *auth.RefreshToken {
 	refreshToken := &auth.RefreshToken{
-		UUID:        uuid,
-		HashedToken: c.cryptoService.HashPassword(refreshTokenStr),
-		UserUUID:    userUUID,
+		UUID:        accessPayload.Jti,
+		HashedToken: c.cryptoService.HashPassword(refreshTokenHashInput(refreshPayload)),
+		UserUUID:    accessPayload.Sub,
+		FamilyUUID:  accessPayload.Sid,
+		IP:          accessPayload.IP,
+		UserAgent:   c.getUserAgent(r),
+		Scope:       accessPayload.Scope,
 		Active:      true,
-		CreatedAt:   time.Unix(Iat, 0),
+		CreatedAt:   time.Unix(accessPayload.Iat, 0),
 	}
 
 	return refreshToken
 }
 
-func (c *AuthController) getUserUUIDFromContext(r *http.Request) (auth.UUID, error) {
-	userUUID, ok := r.Context().Value(CtxUserUUIDKey{}).(auth.UUID)
-	if !ok {
-		return auth.UUID{}, fmt.Errorf("failed to get UUID from context")
-	}
+func (c *AuthController) compareRefreshTokenSecret(refreshToken *auth.RefreshToken, refreshPayload *auth.RefreshPayload) error {
+	return c.cryptoService.ComparePasswords(refreshToken.HashedToken, refreshTokenHashInput(refreshPayload))
+}
 
-	return userUUID, nil
+// Secret and ip fit into bcrypt's input limit, and ip can't be altered without failing the comparison
+func refreshTokenHashInput(refreshPayload *auth.RefreshPayload) string {
+	return base64.StdEncoding.EncodeToString(append(slices.Clone(refreshPayload.Secret), refreshPayload.IP.AsSlice()...))
+}
+
+func (c *AuthController) getUserUUIDFromContext(r *http.Request) (auth.UUID, error) {
+	return c.getUUIDParamFromContext(r, "UserUUID")
 }
 
-func (c *AuthController) getAccessTokenFromContext(r *http.Request) (string, error) {
-	accessToken, ok := r.Context().Value(CtxAccessTokenKey{}).(string)
+func (c *AuthController) getUUIDParamFromContext(r *http.Request, param string) (auth.UUID, error) {
+	paramUUID, ok := r.Context().Value(CtxUUIDParamKey{param}).(auth.UUID)
 	if !ok {
-		return "", fmt.Errorf("failed to get UUID from context")
+		return auth.UUID{}, fmt.Errorf("failed to get %s from context", param)
 	}
 
-	return accessToken, nil
+	return paramUUID, nil
 }
 
 type respParams struct {
@@ -0,0 +1,192 @@
+package chi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	auth "github.com/medods-technical-assessment"
+)
+
+const verificationTokenExpireTime = 24 * time.Hour
+
+var errEmailNotVerified = errors.New("email is not verified")
+
+// Confirms that the user owns the email, by a token sent to it
+func (c *AuthController) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+
+	var verifyInput auth.VerifyEmailDto
+	if err := decoder.Decode(&verifyInput); err != nil {
+		BadRequestErrorHandler(w, err)
+		return
+	}
+
+	if errors := c.validationService.ValidateUserInput(verifyInput); len(errors) > 0 {
+		ValidationErrorHandler(w, errors)
+		return
+	}
+
+	actionPayload, err := c.jwtService.GetActionTokenPayload(verifyInput.Token, auth.ActionPurposeVerifyEmail)
+	if err != nil {
+		BadRequestErrorHandler(w, fmt.Errorf("invalid verification token: %w", err))
+		return
+	}
+
+	user, err := c.service.GetUser(actionPayload.Sub)
+	if err != nil {
+		NotFoundErrorHandler(w, err)
+		return
+	}
+
+	// Token sent to the previous email mustn't verify the new one
+	if user.Email != actionPayload.Email {
+		BadRequestErrorHandler(w, fmt.Errorf("invalid verification token: email has changed since the token was sent"))
+		return
+	}
+
+	if !user.EmailVerified {
+		user.EmailVerified = true
+		if _, err = c.service.UpdateUser(user); err != nil {
+			InternalErrorHandler(w, err)
+			return
+		}
+	}
+
+	if err = c.writeResponse(respParams{w: w, code: http.StatusNoContent}); err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+}
+
+func (c *AuthController) ResendVerificationEmail(w http.ResponseWriter, r *http.Request) {
+	principal, err := GetPrincipal(r.Context())
+	if err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+
+	user, err := c.service.GetUser(principal.UserUUID)
+	if err != nil {
+		NotFoundErrorHandler(w, err)
+		return
+	}
+
+	if user.EmailVerified {
+		ConflictErrorHandler(w, fmt.Errorf("email is already verified"))
+		return
+	}
+
+	if !c.reserveEmail(w, r, user.Email) {
+		return
+	}
+
+	if err = c.sendVerificationEmail(user); err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+
+	if err = c.writeResponse(respParams{w: w, code: http.StatusAccepted}); err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+}
+
+// Sends verification email along with another request, unless too many have been sent to the address or from the ip
+func (c *AuthController) trySendVerificationEmail(r *http.Request, user *auth.User) bool {
+	if !c.isEmailAllowed(r, user.Email) {
+		return false
+	}
+	if err := c.sendVerificationEmail(user); err != nil {
+		log.Print(err)
+		return false
+	}
+
+	return true
+}
+
+func (c *AuthController) sendVerificationEmail(user *auth.User) error {
+	issuedAt := time.Now()
+	actionToken, err := c.jwtService.GenerateActionToken(&auth.ActionPayload{
+		Jti:     c.uuidService.New(),
+		Purpose: auth.ActionPurposeVerifyEmail,
+		Sub:     user.UUID,
+		Email:   user.Email,
+		Iat:     issuedAt.Unix(),
+		Exp:     issuedAt.Add(verificationTokenExpireTime).Unix(),
+	})
+	if err != nil {
+		return err
+	}
+
+	message := fmt.Sprintf(`Please confirm your email using the verification token: %s. It expires in %v.`, actionToken, verificationTokenExpireTime)
+	if c.config.EmailVerificationURL != "" {
+		link, err := makeTokenLink(c.config.EmailVerificationURL, actionToken)
+		if err != nil {
+			return fmt.Errorf("error creating verification link: %w", err)
+		}
+
+		message = fmt.Sprintf(`Please confirm your email by following the link: %s. It expires in %v.`, link, verificationTokenExpireTime)
+	}
+
+	if err = c.mailService.Send(user.Email, "Verify your email", message); err != nil {
+		return fmt.Errorf("error sending verification email: %w", err)
+	}
+
+	return nil
+}
+
+// Returns scope access token is issued with, which is restricted until the user verifies their email.
+// Fails with errEmailNotVerified when unverified users aren't allowed to log in
+func (c *AuthController) applyEmailVerificationPolicy(user *auth.User, scope string) (string, error) {
+	if user.EmailVerified {
+		return scope, nil
+	}
+
+	switch c.config.EmailVerificationPolicy {
+	case auth.EmailVerificationPolicyBlock:
+		return "", errEmailNotVerified
+	case auth.EmailVerificationPolicyRestrict:
+		scopes := slices.DeleteFunc(strings.Fields(scope), func(scope string) bool {
+			return !slices.Contains(auth.UnverifiedEmailScopes, scope)
+		})
+		// Empty scope would mean an unrestricted token
+		if len(scopes) == 0 {
+			return "", fmt.Errorf("%w: requested scope requires verified email", errEmailNotVerified)
+		}
+		return strings.Join(scopes, " "), nil
+	default:
+		return scope, nil
+	}
+}
+
+// Under EmailVerificationPolicyBlock users can't log in to request another verification email,
+// so it is sent whenever they try to, as often as the email limit allows
+func (c *AuthController) handleEmailNotVerified(w http.ResponseWriter, r *http.Request, user *auth.User, err error) {
+	if c.config.EmailVerificationPolicy == auth.EmailVerificationPolicyBlock && c.trySendVerificationEmail(r, user) {
+		ForbiddenErrorHandler(w, fmt.Errorf("%w: verification email has been sent", err))
+		return
+	}
+
+	ForbiddenErrorHandler(w, err)
+}
+
+// Adds the token to the page URL as `token` query param
+func makeTokenLink(pageURL string, token string) (string, error) {
+	link, err := url.Parse(pageURL)
+	if err != nil {
+		return "", err
+	}
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+
+	return link.String(), nil
+}
@@ -3,7 +3,10 @@ package chi
 import (
 	"encoding/json"
 	"log"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
 	auth "github.com/medods-technical-assessment"
 )
@@ -25,9 +28,17 @@ var (
 	NotFoundErrorHandler = func(w http.ResponseWriter, err error) {
 		writeError(w, err.Error(), http.StatusNotFound)
 	}
+	UnauthorizedErrorHandler = func(w http.ResponseWriter, err error) {
+		writeError(w, err.Error(), http.StatusUnauthorized)
+	}
 	ForbiddenErrorHandler = func(w http.ResponseWriter, err error) {
 		writeError(w, err.Error(), http.StatusForbidden)
 	}
+	// Tells the client when to retry, in whole seconds rounded up, so that retrying on time isn't rejected again
+	TooManyRequestsErrorHandler = func(w http.ResponseWriter, err error, retryAfter time.Duration) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		writeError(w, err.Error(), http.StatusTooManyRequests)
+	}
 	InternalErrorHandler = func(w http.ResponseWriter, err error) {
 		log.Print(err)
 		writeError(w, "An Unexpected Error Occured.", http.StatusInternalServerError)
@@ -0,0 +1,54 @@
+package chi
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/netip"
+
+	auth "github.com/medods-technical-assessment"
+	"github.com/medods-technical-assessment/internal/common"
+)
+
+var (
+	errReauthenticationRequired = errors.New("ip address has changed: log in again to continue")
+	errSessionRevokedOnIPChange = errors.New("ip address has changed: session has been revoked")
+)
+
+// Applies the ip change policy to the session being refreshed from the current address.
+// Responds and returns false if the refresh is rejected
+func (c *AuthController) checkIPChange(w http.ResponseWriter, r *http.Request, user *auth.User, refreshToken *auth.RefreshToken, previous netip.Addr, roles []string) bool {
+	ipStr, current := c.getIp(r)
+	action := c.ipChangePolicy.Decide(roles, previous, current)
+	if action == auth.IPChangeActionIgnore {
+		return true
+	}
+
+	details := fmt.Sprintf("session %s was refreshed from ip address %s instead of %s, action: %s", refreshToken.FamilyUUID, ipStr, previous, action)
+	c.recordSecurityEvent(r, user.UUID, auth.SecurityEventIPChange, details)
+
+	switch action {
+	case auth.IPChangeActionNotify:
+		c.mailService.Send(user.Email, "New login", fmt.Sprintf(`We noticed you logged in from a new ip address %s. If this was you, there's nothing for you to do right now.`, ipStr))
+		return true
+	case auth.IPChangeActionReauthenticate:
+		// Session is kept, so that it can still be refreshed from the previous address
+		c.mailService.Send(user.Email, "New login", fmt.Sprintf(`One of your sessions was used from a new ip address %s, so you have been asked to log in again. If this wasn't you, please change your password.`, ipStr))
+		UnauthorizedErrorHandler(w, errReauthenticationRequired)
+		return false
+	default:
+		err := c.service.RevokeRefreshTokenFamily(user.UUID, refreshToken.FamilyUUID)
+		if err != nil && !errors.Is(err, common.ErrRefreshTokenFamilyNotFound) {
+			InternalErrorHandler(w, err)
+			return false
+		}
+		if err = c.denySessionAccessTokens(user.UUID, refreshToken.FamilyUUID); err != nil {
+			log.Print(err)
+		}
+
+		c.mailService.Send(user.Email, "Suspicious activity", fmt.Sprintf(`One of your sessions was used from a new ip address %s, so we have logged it out. If this wasn't you, please change your password.`, ipStr))
+		ForbiddenErrorHandler(w, errSessionRevokedOnIPChange)
+		return false
+	}
+}
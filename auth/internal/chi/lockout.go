@@ -0,0 +1,195 @@
+package chi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	auth "github.com/medods-technical-assessment"
+)
+
+const (
+	// Consecutive failures lock the account, even if made slowly enough to stay within the rate limit
+	accountLockThreshold = 20
+	accountLockDuration  = time.Hour
+)
+
+var errAccountLocked = errors.New("account is locked after too many failed login attempts")
+
+// Lifts the lock with the token sent to the user's email when the account was locked
+func (c *AuthController) UnlockAccount(w http.ResponseWriter, r *http.Request) {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+
+	var unlockInput auth.UnlockAccountDto
+	if err := decoder.Decode(&unlockInput); err != nil {
+		BadRequestErrorHandler(w, err)
+		return
+	}
+
+	if errors := c.validationService.ValidateUserInput(unlockInput); len(errors) > 0 {
+		ValidationErrorHandler(w, errors)
+		return
+	}
+
+	actionPayload, err := c.jwtService.GetActionTokenPayload(unlockInput.Token, auth.ActionPurposeUnlockAccount)
+	if err != nil {
+		BadRequestErrorHandler(w, fmt.Errorf("invalid unlock token: %w", err))
+		return
+	}
+
+	user, err := c.service.GetUser(actionPayload.Sub)
+	if err != nil {
+		NotFoundErrorHandler(w, err)
+		return
+	}
+
+	// Link only proves ownership of the email it was sent to
+	if user.Email != actionPayload.Email {
+		BadRequestErrorHandler(w, fmt.Errorf("invalid unlock token: email has changed since the token was sent"))
+		return
+	}
+
+	if err = c.unlockAccount(user); err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+	c.recordSecurityEvent(r, user.UUID, auth.SecurityEventAccountUnlocked, "account was unlocked by the link sent to email")
+
+	if err = c.writeResponse(respParams{w: w, code: http.StatusNoContent}); err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+}
+
+// Shows support whether the user's login is locked and where failed attempts come from
+func (c *AuthController) GetAccountLockout(w http.ResponseWriter, r *http.Request) {
+	userUUID, err := c.getUserUUIDFromContext(r)
+	if err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+
+	user, err := c.service.GetUser(userUUID)
+	if err != nil {
+		NotFoundErrorHandler(w, err)
+		return
+	}
+
+	if err = c.writeResponse(respParams{w: w, code: http.StatusOK, json: user.ToLockout(time.Now())}); err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+}
+
+// Lifts the lock on behalf of the user, e.g. once support has confirmed their identity
+func (c *AuthController) DeleteAccountLockout(w http.ResponseWriter, r *http.Request) {
+	principal, err := GetPrincipal(r.Context())
+	if err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+
+	userUUID, err := c.getUserUUIDFromContext(r)
+	if err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+
+	user, err := c.service.GetUser(userUUID)
+	if err != nil {
+		NotFoundErrorHandler(w, err)
+		return
+	}
+
+	if err = c.unlockAccount(user); err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+	c.recordSecurityEvent(r, user.UUID, auth.SecurityEventAccountUnlocked, fmt.Sprintf("account was unlocked by %s", principal.UserUUID))
+
+	if err = c.writeResponse(respParams{w: w, code: http.StatusNoContent}); err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+}
+
+// Responds with 403 and returns false if password login of the user is locked. Checked before
+// the password is, so that guesses made while locked aren't confirmed
+func (c *AuthController) checkAccountLock(w http.ResponseWriter, user *auth.User) bool {
+	if !user.IsLocked(time.Now()) {
+		return true
+	}
+
+	ForbiddenErrorHandler(w, fmt.Errorf("%w until %s, follow the link sent to your email to unlock it", errAccountLocked, user.LockedUntil.UTC().Format(time.RFC3339)))
+	return false
+}
+
+// Locks the account once enough failures have been made since the last successful login
+func (c *AuthController) countFailedLogin(r *http.Request, user *auth.User) {
+	ipStr, _ := c.getIp(r)
+	attempts, err := c.service.RecordFailedLogin(user.UUID, ipStr, time.Now())
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	if attempts < accountLockThreshold {
+		return
+	}
+
+	lockedUntil := time.Now().Add(accountLockDuration)
+	if err = c.service.LockUser(user.UUID, lockedUntil); err != nil {
+		log.Print(err)
+		return
+	}
+	c.recordSecurityEvent(r, user.UUID, auth.SecurityEventAccountLocked, fmt.Sprintf("account was locked until %s after %d failed login attempts, last from ip address %s", lockedUntil.UTC().Format(time.RFC3339), attempts, ipStr))
+
+	if err = c.sendUnlockEmail(user, ipStr, lockedUntil); err != nil {
+		log.Print(err)
+	}
+}
+
+// Failures of the rate limiter are forgotten as well, so that the user can log in right away
+func (c *AuthController) unlockAccount(user *auth.User) error {
+	if err := c.service.UnlockUser(user.UUID); err != nil {
+		return err
+	}
+
+	return c.loginLimiter.RecordSuccess(user.Email)
+}
+
+// Unlock token expires with the lock, as there is nothing to unlock afterwards
+func (c *AuthController) sendUnlockEmail(user *auth.User, ipStr string, lockedUntil time.Time) error {
+	issuedAt := time.Now()
+	actionToken, err := c.jwtService.GenerateActionToken(&auth.ActionPayload{
+		Jti:     c.uuidService.New(),
+		Purpose: auth.ActionPurposeUnlockAccount,
+		Sub:     user.UUID,
+		Email:   user.Email,
+		Iat:     issuedAt.Unix(),
+		Exp:     lockedUntil.Unix(),
+	})
+	if err != nil {
+		return err
+	}
+
+	notice := fmt.Sprintf(`Your account was locked for %v after too many failed login attempts, the last one from ip address %s.`, accountLockDuration, ipStr)
+	message := fmt.Sprintf(`%s If it was you, unlock it with the token: %s. If it wasn't, someone may be guessing your password, please consider changing it.`, notice, actionToken)
+	if c.config.AccountUnlockURL != "" {
+		link, err := makeTokenLink(c.config.AccountUnlockURL, actionToken)
+		if err != nil {
+			return fmt.Errorf("error creating unlock link: %w", err)
+		}
+
+		message = fmt.Sprintf(`%s If it was you, unlock it by following the link: %s. If it wasn't, someone may be guessing your password, please consider changing it.`, notice, link)
+	}
+
+	if err = c.mailService.Send(user.Email, "Account locked", message); err != nil {
+		return fmt.Errorf("error sending unlock email: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,133 @@
+package chi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	auth "github.com/medods-technical-assessment"
+)
+
+const magicLinkTokenExpireTime = 15 * time.Minute
+
+// Emails a single-use sign-in link. Responds the same way whether or not a user with the email
+// exists, so that the endpoint can't be used to find out registered emails
+func (c *AuthController) SendMagicLink(w http.ResponseWriter, r *http.Request) {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+
+	var magicLinkInput auth.MagicLinkDto
+	if err := decoder.Decode(&magicLinkInput); err != nil {
+		BadRequestErrorHandler(w, err)
+		return
+	}
+
+	if errors := c.validationService.ValidateUserInput(magicLinkInput); len(errors) > 0 {
+		ValidationErrorHandler(w, errors)
+		return
+	}
+
+	// Shares the limit with password reset emails, as either floods the same inbox
+	if !c.reserveEmail(w, r, magicLinkInput.Email) {
+		return
+	}
+
+	// Sent in the background, as otherwise response time would reveal whether the user exists
+	go func() {
+		user, err := c.service.GetUserByEmail(magicLinkInput.Email)
+		if err != nil {
+			log.Print(err)
+			return
+		}
+		if err = c.sendMagicLinkEmail(user); err != nil {
+			log.Print(err)
+		}
+	}()
+
+	if err := c.writeResponse(respParams{w: w, code: http.StatusAccepted}); err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+}
+
+// Exchanges the token sent by SendMagicLink for tokens. The link only stands in for the password,
+// so users with a second factor enabled get an MFA challenge, same as on Login
+func (c *AuthController) RedeemMagicLink(w http.ResponseWriter, r *http.Request) {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+
+	var redeemInput auth.RedeemMagicLinkDto
+	if err := decoder.Decode(&redeemInput); err != nil {
+		BadRequestErrorHandler(w, err)
+		return
+	}
+
+	if errors := c.validationService.ValidateUserInput(redeemInput); len(errors) > 0 {
+		ValidationErrorHandler(w, errors)
+		return
+	}
+
+	scope, err := c.narrowScope(redeemInput.Scope, auth.Scopes)
+	if err != nil {
+		BadRequestErrorHandler(w, err)
+		return
+	}
+
+	magicLinkToken, err := c.useOneTimeToken(redeemInput.Token, auth.ActionPurposeMagicLink)
+	if err != nil {
+		if errors.Is(err, errInvalidOneTimeToken) {
+			ForbiddenErrorHandler(w, fmt.Errorf("invalid sign-in link: %w", err))
+			return
+		}
+		InternalErrorHandler(w, err)
+		return
+	}
+
+	user, err := c.service.GetUser(magicLinkToken.UserUUID)
+	if err != nil {
+		NotFoundErrorHandler(w, err)
+		return
+	}
+
+	// Token was delivered to the email, which proves the user owns it, unless the email has been changed since
+	if !user.EmailVerified && magicLinkToken.Email == user.Email {
+		user.EmailVerified = true
+		if user, err = c.service.UpdateUser(user); err != nil {
+			InternalErrorHandler(w, err)
+			return
+		}
+	}
+
+	// Links requested along with this one would otherwise stay usable in the mailbox
+	if err = c.service.DeleteOneTimeTokensByUser(user.UUID, auth.ActionPurposeMagicLink); err != nil {
+		log.Print(err)
+	}
+
+	c.handleFirstFactorAuth(w, r, user, scope)
+}
+
+func (c *AuthController) sendMagicLinkEmail(user *auth.User) error {
+	magicLinkToken, err := c.issueOneTimeToken(user, auth.ActionPurposeMagicLink, magicLinkTokenExpireTime)
+	if err != nil {
+		return err
+	}
+
+	message := fmt.Sprintf(`Use the token to sign in: %s. It expires in %v and can only be used once. If you didn't request it, you can ignore this email.`, magicLinkToken, magicLinkTokenExpireTime)
+	if c.config.MagicLinkURL != "" {
+		link, err := makeTokenLink(c.config.MagicLinkURL, magicLinkToken)
+		if err != nil {
+			return fmt.Errorf("error creating sign-in link: %w", err)
+		}
+
+		message = fmt.Sprintf(`Sign in by following the link: %s. It expires in %v and can only be used once. If you didn't request it, you can ignore this email.`, link, magicLinkTokenExpireTime)
+	}
+
+	if err = c.mailService.Send(user.Email, "Sign in", message); err != nil {
+		return fmt.Errorf("error sending sign-in email: %w", err)
+	}
+
+	return nil
+}
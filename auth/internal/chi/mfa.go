@@ -0,0 +1,397 @@
+package chi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	auth "github.com/medods-technical-assessment"
+	"github.com/medods-technical-assessment/internal/common"
+)
+
+const mfaTokenExpireTime = 5 * time.Minute
+
+var (
+	errMFANotEnabled   = errors.New("two-factor authentication is not enabled")
+	errInvalidTOTPCode = errors.New("invalid code")
+)
+
+// Completes login started by Login or LoginByUUID, which returned an MFA challenge
+func (c *AuthController) LoginMFA(w http.ResponseWriter, r *http.Request) {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+
+	var mfaInput auth.LoginMFADto
+	if err := decoder.Decode(&mfaInput); err != nil {
+		BadRequestErrorHandler(w, err)
+		return
+	}
+
+	if errors := c.validationService.ValidateUserInput(mfaInput); len(errors) > 0 {
+		ValidationErrorHandler(w, errors)
+		return
+	}
+
+	mfaPayload, err := c.jwtService.GetActionTokenPayload(mfaInput.MFAToken, auth.ActionPurposeMFA)
+	if err != nil {
+		ForbiddenErrorHandler(w, fmt.Errorf("invalid mfa token: %w", err))
+		return
+	}
+
+	user, err := c.service.GetUser(mfaPayload.Sub)
+	if err != nil {
+		ForbiddenErrorHandler(w, err)
+		return
+	}
+
+	// Challenge proves the password was known, which no longer counts once it has been changed
+	if c.isIssuedBeforePasswordChange(user, mfaPayload.Iat) {
+		ForbiddenErrorHandler(w, fmt.Errorf("invalid mfa token: %w", errIssuedBeforePasswordChange))
+		return
+	}
+
+	// Shares the limit with password attempts of the account, as either guess gets the attacker closer
+	if !c.reserveLoginAttempt(w, r, user.Email) || !c.checkAccountLock(w, user) {
+		return
+	}
+
+	if mfaInput.RecoveryCode != "" {
+		err = c.verifyRecoveryCode(r, user, mfaInput.RecoveryCode)
+	} else {
+		err = c.verifyTOTPCode(user.UUID, mfaInput.Code)
+	}
+	if err != nil {
+		if errors.Is(err, errMFANotEnabled) || errors.Is(err, errInvalidTOTPCode) || errors.Is(err, errInvalidRecoveryCode) {
+			c.countFailedLogin(r, user)
+			ForbiddenErrorHandler(w, err)
+			return
+		}
+		InternalErrorHandler(w, err)
+		return
+	}
+	c.releaseLoginAttempt(r, user.Email)
+
+	// Challenge can be retried after a wrong code, but completed only once
+	if err = c.service.UseOneTimeToken(mfaPayload.Jti); err != nil {
+		if errors.Is(err, common.ErrOneTimeTokenNotFound) {
+			ForbiddenErrorHandler(w, fmt.Errorf("invalid mfa token: %w", errInvalidOneTimeToken))
+			return
+		}
+		InternalErrorHandler(w, err)
+		return
+	}
+
+	c.handleSuccessfulAuth(w, r, user, mfaPayload.Scope)
+}
+
+// Starts enrolment, which has to be confirmed with a code from the authenticator app. Starting over
+// replaces the secret, as long as enrolment hasn't been confirmed yet
+func (c *AuthController) EnrolTOTP(w http.ResponseWriter, r *http.Request) {
+	principal, err := GetPrincipal(r.Context())
+	if err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+
+	user, err := c.service.GetUser(principal.UserUUID)
+	if err != nil {
+		NotFoundErrorHandler(w, err)
+		return
+	}
+
+	credential, err := c.service.GetTOTPCredential(user.UUID)
+	if err != nil && !errors.Is(err, common.ErrTOTPCredentialNotFound) {
+		InternalErrorHandler(w, err)
+		return
+	}
+	if err == nil && credential.Confirmed {
+		ConflictErrorHandler(w, fmt.Errorf("two-factor authentication is already enabled"))
+		return
+	}
+
+	secret, err := c.totpService.GenerateSecret()
+	if err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+
+	err = c.service.SaveTOTPCredential(&auth.TOTPCredential{
+		UserUUID:  user.UUID,
+		Secret:    secret,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+
+	enrolment := &auth.TOTPEnrolment{
+		Secret: secret,
+		URI:    c.totpService.GetURI(secret, user.Email),
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	if err = c.writeResponse(respParams{w: w, code: http.StatusCreated, json: enrolment}); err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+}
+
+// Enables the second factor, once the user proves the authenticator app is set up.
+// Responds with recovery codes, which are only shown this once
+func (c *AuthController) ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	principal, err := GetPrincipal(r.Context())
+	if err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+
+	codeInput, ok := c.decodeTOTPCode(w, r)
+	if !ok {
+		return
+	}
+
+	user, err := c.service.GetUser(principal.UserUUID)
+	if err != nil {
+		NotFoundErrorHandler(w, err)
+		return
+	}
+
+	credential, err := c.service.GetTOTPCredential(principal.UserUUID)
+	if err != nil {
+		if errors.Is(err, common.ErrTOTPCredentialNotFound) {
+			NotFoundErrorHandler(w, fmt.Errorf("two-factor authentication enrolment hasn't been started"))
+			return
+		}
+		InternalErrorHandler(w, err)
+		return
+	}
+	if credential.Confirmed {
+		ConflictErrorHandler(w, fmt.Errorf("two-factor authentication is already enabled"))
+		return
+	}
+
+	// Shares the limit with password attempts of the account, same as LoginMFA
+	if !c.reserveLoginAttempt(w, r, user.Email) || !c.checkAccountLock(w, user) {
+		return
+	}
+
+	step, err := c.totpService.Validate(credential.Secret, codeInput.Code, time.Now())
+	if err != nil {
+		c.countFailedLogin(r, user)
+		BadRequestErrorHandler(w, errInvalidTOTPCode)
+		return
+	}
+	c.releaseLoginAttempt(r, user.Email)
+	// So that the code can't be used to log in once again
+	if err = c.service.UseTOTPStep(principal.UserUUID, step); err != nil && !errors.Is(err, common.ErrTOTPStepUsed) {
+		InternalErrorHandler(w, err)
+		return
+	}
+
+	codes, err := c.issueRecoveryCodes(principal.UserUUID)
+	if err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+
+	if err = c.service.ConfirmTOTPCredential(principal.UserUUID); err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+
+	c.recordSecurityEvent(r, principal.UserUUID, auth.SecurityEventMFAEnabled, "totp authenticator app was confirmed")
+
+	w.Header().Set("Cache-Control", "no-store")
+	if err = c.writeResponse(respParams{w: w, code: http.StatusOK, json: &auth.RecoveryCodes{RecoveryCodes: codes}}); err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+}
+
+// Disables the second factor, provided a current code, so that a stolen access token isn't enough to do so
+func (c *AuthController) DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	principal, err := GetPrincipal(r.Context())
+	if err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+
+	codeInput, ok := c.decodeTOTPCode(w, r)
+	if !ok {
+		return
+	}
+
+	user, err := c.service.GetUser(principal.UserUUID)
+	if err != nil {
+		NotFoundErrorHandler(w, err)
+		return
+	}
+
+	// Shares the limit with password attempts of the account, same as LoginMFA
+	if !c.reserveLoginAttempt(w, r, user.Email) || !c.checkAccountLock(w, user) {
+		return
+	}
+
+	if err = c.verifyTOTPCode(principal.UserUUID, codeInput.Code); err != nil {
+		if errors.Is(err, errMFANotEnabled) {
+			c.releaseLoginAttempt(r, user.Email)
+			NotFoundErrorHandler(w, err)
+			return
+		}
+		if errors.Is(err, errInvalidTOTPCode) {
+			c.countFailedLogin(r, user)
+			ForbiddenErrorHandler(w, err)
+			return
+		}
+		InternalErrorHandler(w, err)
+		return
+	}
+	c.releaseLoginAttempt(r, user.Email)
+
+	if err = c.service.DeleteTOTPCredential(principal.UserUUID); err != nil && !errors.Is(err, common.ErrTOTPCredentialNotFound) {
+		InternalErrorHandler(w, err)
+		return
+	}
+	// Recovery codes are only of use along with the second factor
+	if err = c.service.DeleteRecoveryCodesByUser(principal.UserUUID); err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+
+	c.recordSecurityEvent(r, principal.UserUUID, auth.SecurityEventMFADisabled, "totp authenticator app was removed")
+	ipStr, _ := c.getIp(r)
+	c.mailService.Send(user.Email, "Two-factor authentication disabled", fmt.Sprintf(`Two-factor authentication was disabled for your account from ip address %s. If you didn't do this, please reset your password and enable it again.`, ipStr))
+
+	if err = c.writeResponse(respParams{w: w, code: http.StatusNoContent}); err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+}
+
+func (c *AuthController) decodeTOTPCode(w http.ResponseWriter, r *http.Request) (*auth.TOTPCodeDto, bool) {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+
+	var codeInput auth.TOTPCodeDto
+	if err := decoder.Decode(&codeInput); err != nil {
+		BadRequestErrorHandler(w, err)
+		return nil, false
+	}
+
+	if errors := c.validationService.ValidateUserInput(codeInput); len(errors) > 0 {
+		ValidationErrorHandler(w, errors)
+		return nil, false
+	}
+
+	return &codeInput, true
+}
+
+// Accepts a code of the user's confirmed authenticator app, each code only once
+func (c *AuthController) verifyTOTPCode(userUUID auth.UUID, code string) error {
+	credential, err := c.service.GetTOTPCredential(userUUID)
+	if err != nil {
+		if errors.Is(err, common.ErrTOTPCredentialNotFound) {
+			return errMFANotEnabled
+		}
+		return err
+	}
+	if !credential.Confirmed {
+		return errMFANotEnabled
+	}
+
+	step, err := c.totpService.Validate(credential.Secret, code, time.Now())
+	if err != nil {
+		return errInvalidTOTPCode
+	}
+
+	if err = c.service.UseTOTPStep(userUUID, step); err != nil {
+		if errors.Is(err, common.ErrTOTPStepUsed) {
+			return fmt.Errorf("%w: code has already been used", errInvalidTOTPCode)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Returns second factors the user has enabled, none if login is completed by the first one
+func (c *AuthController) getMFAMethods(userUUID auth.UUID) ([]string, error) {
+	methods := make([]string, 0)
+
+	credential, err := c.service.GetTOTPCredential(userUUID)
+	if err != nil && !errors.Is(err, common.ErrTOTPCredentialNotFound) {
+		return nil, err
+	}
+	if err != nil || !credential.Confirmed {
+		return methods, nil
+	}
+	methods = append(methods, auth.MFAMethodTOTP)
+
+	unusedCount, err := c.service.CountUnusedRecoveryCodes(userUUID)
+	if err != nil {
+		return nil, err
+	}
+	if unusedCount > 0 {
+		methods = append(methods, auth.MFAMethodRecoveryCode)
+	}
+
+	return methods, nil
+}
+
+// Issues tokens right away, unless the user has a second factor enabled, in which case
+// login has to be completed by LoginMFA using the returned challenge
+func (c *AuthController) handleFirstFactorAuth(w http.ResponseWriter, r *http.Request, user *auth.User, scope string) {
+	methods, err := c.getMFAMethods(user.UUID)
+	if err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+	if len(methods) == 0 {
+		c.handleSuccessfulAuth(w, r, user, scope)
+		return
+	}
+
+	issuedAt := time.Now()
+	mfaPayload := &auth.ActionPayload{
+		Jti:     c.uuidService.New(),
+		Purpose: auth.ActionPurposeMFA,
+		Sub:     user.UUID,
+		Email:   user.Email,
+		Scope:   scope,
+		Iat:     issuedAt.Unix(),
+		Exp:     issuedAt.Add(mfaTokenExpireTime).Unix(),
+	}
+	mfaToken, err := c.jwtService.GenerateActionToken(mfaPayload)
+	if err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+
+	// Challenge is a signed token, so only its jti is recorded, for LoginMFA to use it up
+	err = c.service.AddOneTimeToken(&auth.OneTimeToken{
+		UUID:      mfaPayload.Jti,
+		UserUUID:  user.UUID,
+		Purpose:   auth.ActionPurposeMFA,
+		ExpiresAt: issuedAt.Add(mfaTokenExpireTime),
+		CreatedAt: issuedAt,
+		Email:     user.Email,
+	})
+	if err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+
+	challenge := &auth.MFAChallenge{
+		Status:   auth.MFAStatusRequired,
+		MFAToken: mfaToken,
+		Methods:  methods,
+	}
+
+	if err = c.writeResponse(respParams{w: w, code: http.StatusOK, json: challenge}); err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+}
@@ -10,7 +10,10 @@ import (
 	internalchi "github.com/medods-technical-assessment/internal/chi"
 )
 
-func Authorization(jwtService auth.JWTService) func(http.Handler) http.Handler {
+// Verifies the access token and puts the caller's identity into the context,
+// so that handlers don't need to parse the token or look the user up.
+// Tokens of revoked sessions are rejected even before they expire
+func Authorization(jwtService auth.JWTService, denylist auth.AccessTokenDenylist) func(http.Handler) http.Handler {
 
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -22,13 +25,32 @@ func Authorization(jwtService auth.JWTService) func(http.Handler) http.Handler {
 
 			}
 
-			err := jwtService.VerifyAccessToken(accessToken)
+			accessPayload, err := jwtService.GetAccessTokenPayload(accessToken)
 			if err != nil {
 				internalchi.ForbiddenErrorHandler(w, fmt.Errorf("error verifying Authorization header: %w", err))
 				return
 			}
 
-			ctx := context.WithValue(r.Context(), internalchi.CtxAccessTokenKey{}, accessToken)
+			isRevoked, err := denylist.Contains(accessPayload.Jti)
+			if err != nil {
+				internalchi.InternalErrorHandler(w, err)
+				return
+			}
+			if isRevoked {
+				internalchi.ForbiddenErrorHandler(w, fmt.Errorf("error verifying Authorization header: access token is revoked"))
+				return
+			}
+
+			principal := &auth.Principal{
+				UserUUID:    accessPayload.Sub,
+				SessionUUID: accessPayload.Sid,
+				Jti:         accessPayload.Jti,
+				Scopes:      strings.Fields(accessPayload.Scope),
+				Roles:       accessPayload.Roles,
+				Permissions: accessPayload.Permissions,
+			}
+
+			ctx := context.WithValue(r.Context(), internalchi.CtxPrincipalKey{}, principal)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
@@ -0,0 +1,57 @@
+package chi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	auth "github.com/medods-technical-assessment"
+	internalchi "github.com/medods-technical-assessment/internal/chi"
+)
+
+// Authenticates service clients either with a certificate verified against the client CA (mTLS)
+// or with HTTP Basic credentials, requires them to have the grant and puts the client into the context
+// ref: https://datatracker.ietf.org/doc/html/rfc6749#section-2.3.1
+// ref: https://datatracker.ietf.org/doc/html/rfc8705#section-2.1
+func ClientAuthentication(clientService auth.ClientService, grant string) func(http.Handler) http.Handler {
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+			var client *auth.Client
+			// Certificate is only verified if the server is configured with the client CA
+			if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+				var err error
+				client, err = clientService.AuthenticateClientCertificate(r.TLS.VerifiedChains[0][0])
+				if err != nil {
+					internalchi.UnauthorizedErrorHandler(w, fmt.Errorf("error verifying client certificate: %w", err))
+					return
+				}
+			} else {
+				clientID, clientSecret, ok := r.BasicAuth()
+				if !ok {
+					w.Header().Set("WWW-Authenticate", `Basic realm="auth", charset="UTF-8"`)
+					internalchi.UnauthorizedErrorHandler(w, fmt.Errorf("error verifying Authorization header: client credentials must be provided"))
+					return
+				}
+
+				var err error
+				client, err = clientService.AuthenticateClient(clientID, clientSecret)
+				if err != nil {
+					w.Header().Set("WWW-Authenticate", `Basic realm="auth", charset="UTF-8"`)
+					internalchi.UnauthorizedErrorHandler(w, fmt.Errorf("error verifying Authorization header: %w", err))
+					return
+				}
+			}
+
+			if !client.HasGrant(grant) {
+				internalchi.ForbiddenErrorHandler(w, fmt.Errorf("client %s is not allowed to %s", client.ID, grant))
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), internalchi.CtxClientKey{}, client)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+
+}
@@ -0,0 +1,31 @@
+package chi
+
+import (
+	"fmt"
+	"net/http"
+
+	internalchi "github.com/medods-technical-assessment/internal/chi"
+)
+
+// Requires the caller to have the permission. Must be used after the Authorization middleware
+func RequirePermission(permission string) func(http.Handler) http.Handler {
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+			principal, err := internalchi.GetPrincipal(r.Context())
+			if err != nil {
+				internalchi.InternalErrorHandler(w, err)
+				return
+			}
+
+			if !principal.HasPermission(permission) {
+				internalchi.ForbiddenErrorHandler(w, fmt.Errorf("missing permission %s", permission))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+
+}
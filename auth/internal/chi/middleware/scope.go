@@ -0,0 +1,31 @@
+package chi
+
+import (
+	"fmt"
+	"net/http"
+
+	internalchi "github.com/medods-technical-assessment/internal/chi"
+)
+
+// Requires the access token to be granted the scope. Must be used after the Authorization middleware
+func RequireScope(scope string) func(http.Handler) http.Handler {
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+			principal, err := internalchi.GetPrincipal(r.Context())
+			if err != nil {
+				internalchi.InternalErrorHandler(w, err)
+				return
+			}
+
+			if !principal.HasScope(scope) {
+				internalchi.ForbiddenErrorHandler(w, fmt.Errorf("insufficient scope: %s is required", scope))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+
+}
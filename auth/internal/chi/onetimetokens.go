@@ -0,0 +1,84 @@
+package chi
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	auth "github.com/medods-technical-assessment"
+	"github.com/medods-technical-assessment/internal/common"
+)
+
+const oneTimeTokenSecretLength = 32
+
+var errInvalidOneTimeToken = errors.New("invalid or expired token")
+
+// Issues a token which can be used once for the purpose within the ttl, to be sent to user's current email. Token is
+// the UUID of its record followed by a random secret, so that the record can be found without storing the token itself
+func (c *AuthController) issueOneTimeToken(user *auth.User, purpose auth.ActionPurpose, ttl time.Duration) (string, error) {
+	secret := make([]byte, oneTimeTokenSecretLength)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("error generating one-time token: %w", err)
+	}
+
+	tokenUUID := c.uuidService.New()
+	token := base64.RawURLEncoding.EncodeToString(append(tokenUUID[:], secret...))
+
+	issuedAt := time.Now()
+	err := c.service.AddOneTimeToken(&auth.OneTimeToken{
+		UUID:        tokenUUID,
+		UserUUID:    user.UUID,
+		Purpose:     purpose,
+		Email:       user.Email,
+		HashedToken: c.cryptoService.HashPassword(token),
+		ExpiresAt:   issuedAt.Add(ttl),
+		CreatedAt:   issuedAt,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// Uses up the token, returning its record if it was issued for the purpose and is still valid.
+// Fails with errInvalidOneTimeToken otherwise, without telling why
+func (c *AuthController) useOneTimeToken(token string, purpose auth.ActionPurpose) (*auth.OneTimeToken, error) {
+	tokenBytes, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(tokenBytes) != 16+oneTimeTokenSecretLength {
+		return nil, errInvalidOneTimeToken
+	}
+
+	tokenUUID, err := c.uuidService.FromBytes(tokenBytes[:16])
+	if err != nil {
+		return nil, errInvalidOneTimeToken
+	}
+
+	oneTimeToken, err := c.service.GetOneTimeToken(tokenUUID)
+	if err != nil {
+		if errors.Is(err, common.ErrOneTimeTokenNotFound) {
+			return nil, errInvalidOneTimeToken
+		}
+		return nil, err
+	}
+
+	if oneTimeToken.Purpose != purpose || oneTimeToken.UsedAt != nil || time.Now().After(oneTimeToken.ExpiresAt) {
+		return nil, errInvalidOneTimeToken
+	}
+
+	if err = c.cryptoService.ComparePasswords(oneTimeToken.HashedToken, token); err != nil {
+		return nil, errInvalidOneTimeToken
+	}
+
+	// Fails if another request has used the token in the meantime
+	if err = c.service.UseOneTimeToken(oneTimeToken.UUID); err != nil {
+		if errors.Is(err, common.ErrOneTimeTokenNotFound) {
+			return nil, errInvalidOneTimeToken
+		}
+		return nil, err
+	}
+
+	return oneTimeToken, nil
+}
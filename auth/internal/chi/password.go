@@ -0,0 +1,276 @@
+package chi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	auth "github.com/medods-technical-assessment"
+	"github.com/medods-technical-assessment/internal/common"
+)
+
+const passwordResetTokenExpireTime = 30 * time.Minute
+
+var errIssuedBeforePasswordChange = errors.New("token was issued before the password was changed")
+
+// Sets a new password, provided the current one. Other sessions are logged out, while the caller's
+// session is kept with new tokens, as the ones it holds are issued before the change and are rejected from now on
+func (c *AuthController) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	principal, err := GetPrincipal(r.Context())
+	if err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+
+	var changeInput auth.ChangePasswordDto
+	if err := decoder.Decode(&changeInput); err != nil {
+		BadRequestErrorHandler(w, err)
+		return
+	}
+
+	if errors := c.validationService.ValidateUserInput(changeInput); len(errors) > 0 {
+		ValidationErrorHandler(w, errors)
+		return
+	}
+
+	user, err := c.service.GetUser(principal.UserUUID)
+	if err != nil {
+		NotFoundErrorHandler(w, err)
+		return
+	}
+
+	// Shares the limit with password attempts of the account, so that a stolen access token can't be used to guess the password
+	if !c.reserveLoginAttempt(w, r, user.Email) || !c.checkAccountLock(w, user) {
+		return
+	}
+
+	if err = c.cryptoService.ComparePasswords(user.Password, changeInput.CurrentPassword); err != nil {
+		c.countFailedLogin(r, user)
+		ForbiddenErrorHandler(w, fmt.Errorf("current password is incorrect"))
+		return
+	}
+	c.releaseLoginAttempt(r, user.Email)
+
+	refreshToken, err := c.service.GetActiveRefreshTokenByFamily(principal.SessionUUID)
+	if err != nil {
+		ForbiddenErrorHandler(w, err)
+		return
+	}
+
+	changedAt := time.Now()
+	user.Password = c.cryptoService.HashPassword(changeInput.NewPassword)
+	user.PasswordChangedAt = &changedAt
+	if _, err = c.service.UpdateUser(user); err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+
+	if err = c.service.RevokeOtherRefreshTokenFamilies(user.UUID, principal.SessionUUID); err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+	// Includes the caller's access token, before the new one is issued
+	if err = c.denyAllSessionsAccessTokens(user.UUID); err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+
+	// New access token keeps scopes of the caller's one
+	grantedScopes := strings.Fields(refreshToken.Scope)
+	if len(grantedScopes) == 0 {
+		grantedScopes = auth.Scopes
+	}
+	scope, err := c.narrowScope(strings.Join(principal.Scopes, " "), grantedScopes)
+	if err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+
+	refreshPayload, accessPayload, err := c.createPayloads(r, user.UUID, principal.SessionUUID, scope)
+	if err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+
+	accessTokenStr, refreshTokenStr, err := c.jwtService.GenerateTokens(refreshPayload, accessPayload)
+	if err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+
+	newRefreshToken := c.makeRefreshToken(r, refreshPayload, accessPayload)
+	newRefreshToken.ParentUUID = &refreshToken.UUID
+	newRefreshToken.Scope = refreshToken.Scope
+
+	if err = c.service.RotateRefreshToken(refreshToken.UUID, newRefreshToken); err != nil {
+		if errors.Is(err, common.ErrRefreshTokenNotActive) {
+			ForbiddenErrorHandler(w, err)
+			return
+		}
+		InternalErrorHandler(w, err)
+		return
+	}
+
+	c.recordSecurityEvent(r, user.UUID, auth.SecurityEventPasswordChange, fmt.Sprintf("password was changed in session %s, other sessions have been revoked", principal.SessionUUID))
+	ipStr, _ := c.getIp(r)
+	c.mailService.Send(user.Email, "Your password has been changed", fmt.Sprintf(`Your password was changed from ip address %s and you have been logged out of all other devices. If you didn't do this, please reset your password.`, ipStr))
+
+	tokens := &auth.Tokens{
+		AccessToken:  accessTokenStr,
+		RefreshToken: refreshTokenStr,
+	}
+
+	if err = c.writeResponse(respParams{w: w, code: http.StatusOK, json: tokens}); err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+}
+
+// Sends a password reset token to the email. Responds the same way whether or not
+// a user with the email exists, so that the endpoint can't be used to find out registered emails
+func (c *AuthController) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+
+	var forgotInput auth.ForgotPasswordDto
+	if err := decoder.Decode(&forgotInput); err != nil {
+		BadRequestErrorHandler(w, err)
+		return
+	}
+
+	if errors := c.validationService.ValidateUserInput(forgotInput); len(errors) > 0 {
+		ValidationErrorHandler(w, errors)
+		return
+	}
+
+	if !c.reserveEmail(w, r, forgotInput.Email) {
+		return
+	}
+
+	// Sent in the background, as otherwise response time would reveal whether the user exists
+	go func() {
+		user, err := c.service.GetUserByEmail(forgotInput.Email)
+		if err != nil {
+			log.Print(err)
+			return
+		}
+		if err = c.sendPasswordResetEmail(user); err != nil {
+			log.Print(err)
+		}
+	}()
+
+	if err := c.writeResponse(respParams{w: w, code: http.StatusAccepted}); err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+}
+
+// Sets a new password using the token sent by ForgotPassword, logging the user out of all sessions
+func (c *AuthController) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+
+	var resetInput auth.ResetPasswordDto
+	if err := decoder.Decode(&resetInput); err != nil {
+		BadRequestErrorHandler(w, err)
+		return
+	}
+
+	if errors := c.validationService.ValidateUserInput(resetInput); len(errors) > 0 {
+		ValidationErrorHandler(w, errors)
+		return
+	}
+
+	resetToken, err := c.useOneTimeToken(resetInput.Token, auth.ActionPurposeResetPassword)
+	if err != nil {
+		if errors.Is(err, errInvalidOneTimeToken) {
+			BadRequestErrorHandler(w, fmt.Errorf("invalid reset token: %w", err))
+			return
+		}
+		InternalErrorHandler(w, err)
+		return
+	}
+
+	user, err := c.service.GetUser(resetToken.UserUUID)
+	if err != nil {
+		NotFoundErrorHandler(w, err)
+		return
+	}
+
+	// Token was delivered to the email, which proves the user owns it, unless the email has been changed since
+	changedAt := time.Now()
+	user.Password = c.cryptoService.HashPassword(resetInput.Password)
+	user.PasswordChangedAt = &changedAt
+	if resetToken.Email == user.Email {
+		user.EmailVerified = true
+	}
+	if _, err = c.service.UpdateUser(user); err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+
+	// Other reset links might have been requested by whoever knew the old password, or sent along with this one
+	if err = c.service.DeleteOneTimeTokensByUser(user.UUID, auth.ActionPurposeResetPassword); err != nil {
+		log.Print(err)
+	}
+
+	// Whoever has been using the account with the old password is logged out
+	if err = c.service.RevokeRefreshTokensByUser(user.UUID); err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+	if err = c.denyAllSessionsAccessTokens(user.UUID); err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+	// Guesses of the old password no longer matter, and the reset token proves ownership of the email
+	if err = c.unlockAccount(user); err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+
+	c.recordSecurityEvent(r, user.UUID, auth.SecurityEventPasswordReset, fmt.Sprintf("password was reset with token %s, all sessions have been revoked", resetToken.UUID))
+	ipStr, _ := c.getIp(r)
+	c.mailService.Send(user.Email, "Your password has been reset", fmt.Sprintf(`Your password was reset from ip address %s and you have been logged out of all devices. If you didn't do this, please reset your password again and check your email account's security.`, ipStr))
+
+	if err = c.writeResponse(respParams{w: w, code: http.StatusNoContent}); err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+}
+
+func (c *AuthController) sendPasswordResetEmail(user *auth.User) error {
+	resetToken, err := c.issueOneTimeToken(user, auth.ActionPurposeResetPassword, passwordResetTokenExpireTime)
+	if err != nil {
+		return err
+	}
+
+	message := fmt.Sprintf(`Use the token to reset your password: %s. It expires in %v. If you didn't request a password reset, you can ignore this email.`, resetToken, passwordResetTokenExpireTime)
+	if c.config.PasswordResetURL != "" {
+		link, err := makeTokenLink(c.config.PasswordResetURL, resetToken)
+		if err != nil {
+			return fmt.Errorf("error creating password reset link: %w", err)
+		}
+
+		message = fmt.Sprintf(`Reset your password by following the link: %s. It expires in %v. If you didn't request a password reset, you can ignore this email.`, link, passwordResetTokenExpireTime)
+	}
+
+	if err = c.mailService.Send(user.Email, "Reset your password", message); err != nil {
+		return fmt.Errorf("error sending password reset email: %w", err)
+	}
+
+	return nil
+}
+
+// Password change ends all sessions established before it, except the one it was made in,
+// which is issued new tokens. Compared in seconds, which is the precision of `iat`
+func (c *AuthController) isIssuedBeforePasswordChange(user *auth.User, issuedAt int64) bool {
+	return user.PasswordChangedAt != nil && issuedAt < user.PasswordChangedAt.Unix()
+}
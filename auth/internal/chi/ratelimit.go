@@ -0,0 +1,67 @@
+package chi
+
+import (
+	"errors"
+	"log"
+	"net/http"
+)
+
+var (
+	errTooManyLoginAttempts = errors.New("too many failed login attempts, try again later")
+	errTooManyEmails        = errors.New("too many emails requested, try again later")
+)
+
+// Counts the attempt before the password or code is checked, so that concurrent guesses can't all
+// get through before any of them has failed. Responds with 429 and returns false if the client has
+// to wait before the next login attempt
+func (c *AuthController) reserveLoginAttempt(w http.ResponseWriter, r *http.Request, account string) bool {
+	ipStr, _ := c.getIp(r)
+	wait, err := c.loginLimiter.Reserve(account, ipStr)
+	if err != nil {
+		InternalErrorHandler(w, err)
+		return false
+	}
+	if wait > 0 {
+		TooManyRequestsErrorHandler(w, errTooManyLoginAttempts, wait)
+		return false
+	}
+
+	return true
+}
+
+// Failure to take the attempt back doesn't fail the request, as it only slows down the client a little
+func (c *AuthController) releaseLoginAttempt(r *http.Request, account string) {
+	ipStr, _ := c.getIp(r)
+	if err := c.loginLimiter.Release(account, ipStr); err != nil {
+		log.Print(err)
+	}
+}
+
+// Counted whether or not a user with the email exists, so that the limit doesn't tell which accounts exist.
+// Responds with 429 and returns false if the client has to wait before another email is sent
+func (c *AuthController) reserveEmail(w http.ResponseWriter, r *http.Request, email string) bool {
+	ipStr, _ := c.getIp(r)
+	wait, err := c.emailLimiter.Reserve(email, ipStr)
+	if err != nil {
+		InternalErrorHandler(w, err)
+		return false
+	}
+	if wait > 0 {
+		TooManyRequestsErrorHandler(w, errTooManyEmails, wait)
+		return false
+	}
+
+	return true
+}
+
+// For emails sent along with another request, which succeeds whether or not the email is sent
+func (c *AuthController) isEmailAllowed(r *http.Request, email string) bool {
+	ipStr, _ := c.getIp(r)
+	wait, err := c.emailLimiter.Reserve(email, ipStr)
+	if err != nil {
+		log.Print(err)
+		return false
+	}
+
+	return wait == 0
+}
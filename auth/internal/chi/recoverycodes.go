@@ -0,0 +1,204 @@
+package chi
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	auth "github.com/medods-technical-assessment"
+	"github.com/medods-technical-assessment/internal/common"
+)
+
+const (
+	recoveryCodesCount = 10
+	// Codes are 12 base32 characters, i.e. 60 bits, shown in groups of 4 like `abcd-efgh-ijkl`.
+	// First group is the lookup, the remaining 40 bits are only known to the user
+	recoveryCodeLength       = 12
+	recoveryCodeLookupLength = 4
+	recoveryCodeGroupLength  = 4
+)
+
+var (
+	errInvalidRecoveryCode = errors.New("invalid recovery code")
+	base32Lowercase        = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+)
+
+// Replaces recovery codes of the user with a new set, which has to be shown to the user right away,
+// since only hashes of the codes are kept
+func (c *AuthController) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	principal, err := GetPrincipal(r.Context())
+	if err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+
+	var regenerateInput auth.RegenerateRecoveryCodesDto
+	if err := decoder.Decode(&regenerateInput); err != nil {
+		BadRequestErrorHandler(w, err)
+		return
+	}
+
+	if errors := c.validationService.ValidateUserInput(regenerateInput); len(errors) > 0 {
+		ValidationErrorHandler(w, errors)
+		return
+	}
+
+	user, err := c.service.GetUser(principal.UserUUID)
+	if err != nil {
+		NotFoundErrorHandler(w, err)
+		return
+	}
+
+	// Shares the limit with password attempts of the account, same as LoginMFA
+	if !c.reserveLoginAttempt(w, r, user.Email) || !c.checkAccountLock(w, user) {
+		return
+	}
+
+	// So that a stolen access token isn't enough to get codes which bypass the second factor
+	if err = c.cryptoService.ComparePasswords(user.Password, regenerateInput.Password); err != nil {
+		c.countFailedLogin(r, user)
+		ForbiddenErrorHandler(w, fmt.Errorf("password is incorrect"))
+		return
+	}
+	if err = c.verifyTOTPCode(user.UUID, regenerateInput.Code); err != nil {
+		if errors.Is(err, errMFANotEnabled) {
+			c.releaseLoginAttempt(r, user.Email)
+			NotFoundErrorHandler(w, err)
+			return
+		}
+		if errors.Is(err, errInvalidTOTPCode) {
+			c.countFailedLogin(r, user)
+			ForbiddenErrorHandler(w, err)
+			return
+		}
+		InternalErrorHandler(w, err)
+		return
+	}
+	c.releaseLoginAttempt(r, user.Email)
+
+	codes, err := c.issueRecoveryCodes(principal.UserUUID)
+	if err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+
+	c.recordSecurityEvent(r, principal.UserUUID, auth.SecurityEventRecoveryCodesRegenerated, "previous recovery codes have been invalidated")
+	ipStr, _ := c.getIp(r)
+	c.mailService.Send(user.Email, "New recovery codes", fmt.Sprintf(`New recovery codes were generated for your account from ip address %s, so the previous ones no longer work. If you didn't do this, please reset your password.`, ipStr))
+
+	w.Header().Set("Cache-Control", "no-store")
+	if err = c.writeResponse(respParams{w: w, code: http.StatusCreated, json: &auth.RecoveryCodes{RecoveryCodes: codes}}); err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+}
+
+// Generates a new set of codes, replacing the previous one, and returns the codes formatted for the user
+func (c *AuthController) issueRecoveryCodes(userUUID auth.UUID) ([]string, error) {
+	codes := make([]string, 0, recoveryCodesCount)
+	lookups := make(map[string]bool, recoveryCodesCount)
+	for len(codes) < recoveryCodesCount {
+		random := make([]byte, 8)
+		if _, err := rand.Read(random); err != nil {
+			return nil, fmt.Errorf("error generating recovery code: %w", err)
+		}
+		code := base32Lowercase.EncodeToString(random)[:recoveryCodeLength]
+
+		// Lookup has to identify a single code of the user
+		if lookups[code[:recoveryCodeLookupLength]] {
+			continue
+		}
+		lookups[code[:recoveryCodeLookupLength]] = true
+		codes = append(codes, code)
+	}
+
+	// Hashing is deliberately slow, so codes are hashed concurrently
+	recoveryCodes := make([]*auth.RecoveryCode, len(codes))
+	var wg sync.WaitGroup
+	for i, code := range codes {
+		recoveryCodes[i] = &auth.RecoveryCode{
+			UUID:     c.uuidService.New(),
+			UserUUID: userUUID,
+			Lookup:   code[:recoveryCodeLookupLength],
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			recoveryCodes[i].HashedCode = c.cryptoService.HashPassword(code)
+		}()
+	}
+	wg.Wait()
+
+	if err := c.service.ReplaceRecoveryCodes(userUUID, recoveryCodes); err != nil {
+		return nil, err
+	}
+
+	formattedCodes := make([]string, 0, len(codes))
+	for _, code := range codes {
+		formattedCodes = append(formattedCodes, formatRecoveryCode(code))
+	}
+
+	return formattedCodes, nil
+}
+
+// Uses up the recovery code of the user, alerting them, as recovery codes are only expected
+// to be used when the second factor is lost
+func (c *AuthController) verifyRecoveryCode(r *http.Request, user *auth.User, code string) error {
+	code = normalizeRecoveryCode(code)
+	if len(code) != recoveryCodeLength {
+		return errInvalidRecoveryCode
+	}
+
+	recoveryCode, err := c.service.GetRecoveryCodeByLookup(user.UUID, code[:recoveryCodeLookupLength])
+	if err != nil {
+		if errors.Is(err, common.ErrRecoveryCodeNotFound) {
+			return errInvalidRecoveryCode
+		}
+		return err
+	}
+
+	if err = c.cryptoService.ComparePasswords(recoveryCode.HashedCode, code); err != nil {
+		return errInvalidRecoveryCode
+	}
+
+	if err = c.service.UseRecoveryCode(recoveryCode.UUID); err != nil {
+		if errors.Is(err, common.ErrRecoveryCodeNotFound) {
+			return errInvalidRecoveryCode
+		}
+		return err
+	}
+
+	remaining, err := c.service.CountUnusedRecoveryCodes(user.UUID)
+	if err != nil {
+		return err
+	}
+
+	c.recordSecurityEvent(r, user.UUID, auth.SecurityEventRecoveryCodeUsed, fmt.Sprintf("recovery code %s was used to log in, %d codes left", recoveryCode.UUID, remaining))
+	ipStr, _ := c.getIp(r)
+	c.mailService.Send(user.Email, "Recovery code used", fmt.Sprintf(`One of your recovery codes was used to log in from ip address %s, %d codes left. If you have lost your authenticator app, please set it up again and generate new recovery codes. If you don't recognize this activity, please reset your password.`, ipStr, remaining))
+
+	return nil
+}
+
+// Codes are accepted regardless of case and grouping
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+func formatRecoveryCode(code string) string {
+	groups := make([]string, 0, len(code)/recoveryCodeGroupLength)
+	for i := 0; i < len(code); i += recoveryCodeGroupLength {
+		groups = append(groups, code[i:min(i+recoveryCodeGroupLength, len(code))])
+	}
+
+	return strings.Join(groups, "-")
+}
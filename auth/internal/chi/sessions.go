@@ -0,0 +1,222 @@
+package chi
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	auth "github.com/medods-technical-assessment"
+	"github.com/medods-technical-assessment/internal/common"
+)
+
+func (c *AuthController) GetSessions(w http.ResponseWriter, r *http.Request) {
+	principal, err := GetPrincipal(r.Context())
+	if err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+
+	sessions, err := c.service.GetSessionsByUser(principal.UserUUID)
+	if err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+
+	for _, session := range sessions {
+		session.Current = session.UUID == principal.SessionUUID
+	}
+
+	if err = c.writeResponse(respParams{w: w, code: http.StatusOK, json: sessions}); err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+}
+
+func (c *AuthController) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	sessionUUID, err := c.getUUIDParamFromContext(r, "SessionUUID")
+	if err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+
+	principal, err := GetPrincipal(r.Context())
+	if err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+
+	if !c.revokeSession(w, principal.UserUUID, sessionUUID) {
+		return
+	}
+
+	if err = c.writeResponse(respParams{w: w, code: http.StatusNoContent}); err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+}
+
+// Lists sessions of any user, so that support can find the one to revoke
+func (c *AuthController) GetUserSessions(w http.ResponseWriter, r *http.Request) {
+	userUUID, err := c.getUserUUIDFromContext(r)
+	if err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+
+	sessions, err := c.service.GetSessionsByUser(userUUID)
+	if err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+
+	if err = c.writeResponse(respParams{w: w, code: http.StatusOK, json: sessions}); err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+}
+
+// Revokes a session of any user, e.g. a stolen one. Recorded along with who revoked it
+func (c *AuthController) RevokeUserSession(w http.ResponseWriter, r *http.Request) {
+	userUUID, err := c.getUserUUIDFromContext(r)
+	if err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+
+	sessionUUID, err := c.getUUIDParamFromContext(r, "SessionUUID")
+	if err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+
+	principal, err := GetPrincipal(r.Context())
+	if err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+
+	if !c.revokeSession(w, userUUID, sessionUUID) {
+		return
+	}
+	c.recordSecurityEvent(r, userUUID, auth.SecurityEventSessionRevoked, fmt.Sprintf("session %s was revoked by user %s", sessionUUID, principal.UserUUID))
+
+	if err = c.writeResponse(respParams{w: w, code: http.StatusNoContent}); err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+}
+
+// Revokes all sessions of any user, e.g. when their account has been taken over. Recorded along with who revoked them
+func (c *AuthController) RevokeUserSessions(w http.ResponseWriter, r *http.Request) {
+	userUUID, err := c.getUserUUIDFromContext(r)
+	if err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+
+	principal, err := GetPrincipal(r.Context())
+	if err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+
+	if err = c.service.RevokeRefreshTokensByUser(userUUID); err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+	if err = c.denyAllSessionsAccessTokens(userUUID); err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+	c.recordSecurityEvent(r, userUUID, auth.SecurityEventSessionRevoked, fmt.Sprintf("all sessions were revoked by user %s", principal.UserUUID))
+
+	if err = c.writeResponse(respParams{w: w, code: http.StatusNoContent}); err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+}
+
+// Responds and returns false if the session isn't an active session of the user
+func (c *AuthController) revokeSession(w http.ResponseWriter, userUUID auth.UUID, sessionUUID auth.UUID) bool {
+	err := c.service.RevokeRefreshTokenFamily(userUUID, sessionUUID)
+	if err != nil {
+		if errors.Is(err, common.ErrRefreshTokenFamilyNotFound) {
+			NotFoundErrorHandler(w, fmt.Errorf("session not found: %w", err))
+			return false
+		}
+		InternalErrorHandler(w, err)
+		return false
+	}
+
+	if err = c.denySessionAccessTokens(userUUID, sessionUUID); err != nil {
+		InternalErrorHandler(w, err)
+		return false
+	}
+
+	return true
+}
+
+// Revokes all of user's sessions, or all but the current one with `?except=current`
+func (c *AuthController) RevokeSessions(w http.ResponseWriter, r *http.Request) {
+	except := r.URL.Query().Get("except")
+	if except != "" && except != "current" {
+		BadRequestErrorHandler(w, fmt.Errorf("invalid value of except query param: %s", except))
+		return
+	}
+
+	principal, err := GetPrincipal(r.Context())
+	if err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+
+	if except == "current" {
+		err = c.service.RevokeOtherRefreshTokenFamilies(principal.UserUUID, principal.SessionUUID)
+	} else {
+		err = c.service.RevokeRefreshTokensByUser(principal.UserUUID)
+	}
+	if err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+
+	if except == "current" {
+		err = c.denyOtherSessionsAccessTokens(principal.UserUUID, principal.SessionUUID)
+	} else {
+		err = c.denyAllSessionsAccessTokens(principal.UserUUID)
+	}
+	if err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+
+	if err = c.writeResponse(respParams{w: w, code: http.StatusNoContent}); err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+}
+
+// Logs out of the current session. Logging out of an already revoked session succeeds as well
+func (c *AuthController) Logout(w http.ResponseWriter, r *http.Request) {
+	principal, err := GetPrincipal(r.Context())
+	if err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+
+	err = c.service.RevokeRefreshTokenFamily(principal.UserUUID, principal.SessionUUID)
+	if err != nil && !errors.Is(err, common.ErrRefreshTokenFamilyNotFound) {
+		InternalErrorHandler(w, err)
+		return
+	}
+
+	if err = c.denySessionAccessTokens(principal.UserUUID, principal.SessionUUID); err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+
+	if err = c.writeResponse(respParams{w: w, code: http.StatusNoContent}); err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+}
@@ -0,0 +1,233 @@
+package chi
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	auth "github.com/medods-technical-assessment"
+	"github.com/medods-technical-assessment/internal/common"
+)
+
+const (
+	tokenTypeAccessToken  = "access_token"
+	tokenTypeRefreshToken = "refresh_token"
+)
+
+// Token is invalid, expired, revoked or was never issued by the service
+var errInactiveToken = errors.New("token is not active")
+
+// Tells internal services whether the token is active, and if so, whom it belongs to.
+// Unlike verifying an access token locally, this also takes into account sessions revoked
+// before their access tokens expired
+//
+// ref: https://datatracker.ietf.org/doc/html/rfc7662
+func (c *AuthController) Introspect(w http.ResponseWriter, r *http.Request) {
+	token, hint, err := c.parseTokenForm(r)
+	if err != nil {
+		BadRequestErrorHandler(w, err)
+		return
+	}
+
+	introspection := &auth.IntrospectionResponse{Active: false}
+	for _, tokenType := range c.tokenTypesByHint(hint) {
+		if tokenType == tokenTypeAccessToken {
+			introspection, err = c.introspectAccessToken(token)
+		} else {
+			introspection, err = c.introspectRefreshToken(token)
+		}
+		if err != nil {
+			InternalErrorHandler(w, err)
+			return
+		}
+		if introspection.Active {
+			break
+		}
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	if err = c.writeResponse(respParams{w: w, code: http.StatusOK, json: introspection}); err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+}
+
+// Revokes the session the access or refresh token belongs to on behalf of an authenticated client.
+// Responds with 200 OK even if the token is invalid or already revoked, so repeating the request is safe
+//
+// ref: https://datatracker.ietf.org/doc/html/rfc7009
+func (c *AuthController) Revoke(w http.ResponseWriter, r *http.Request) {
+	token, hint, err := c.parseTokenForm(r)
+	if err != nil {
+		BadRequestErrorHandler(w, err)
+		return
+	}
+
+	for _, tokenType := range c.tokenTypesByHint(hint) {
+		var userUUID, sessionUUID auth.UUID
+		if tokenType == tokenTypeAccessToken {
+			var accessPayload *auth.AccessPayload
+			accessPayload, err = c.getActiveAccessToken(token)
+			if err == nil {
+				userUUID, sessionUUID = accessPayload.Sub, accessPayload.Sid
+			}
+		} else {
+			var refreshToken *auth.RefreshToken
+			refreshToken, err = c.getActiveRefreshToken(token)
+			if err == nil {
+				userUUID, sessionUUID = refreshToken.UserUUID, refreshToken.FamilyUUID
+			}
+		}
+		if errors.Is(err, errInactiveToken) {
+			continue
+		}
+		if err != nil {
+			InternalErrorHandler(w, err)
+			return
+		}
+
+		// Revoking either token ends the whole session, as neither is of any use without the other
+		err = c.service.RevokeRefreshTokenFamily(userUUID, sessionUUID)
+		if err != nil && !errors.Is(err, common.ErrRefreshTokenFamilyNotFound) {
+			InternalErrorHandler(w, err)
+			return
+		}
+		if err = c.denySessionAccessTokens(userUUID, sessionUUID); err != nil {
+			InternalErrorHandler(w, err)
+			return
+		}
+		break
+	}
+
+	if err = c.writeResponse(respParams{w: w, code: http.StatusOK}); err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+}
+
+// Returns `token` and `token_type_hint` of the form-encoded body
+func (c *AuthController) parseTokenForm(r *http.Request) (token string, hint string, err error) {
+	if err = r.ParseForm(); err != nil {
+		return "", "", err
+	}
+
+	token = r.PostForm.Get("token")
+	if token == "" {
+		return "", "", fmt.Errorf("token must be provided")
+	}
+
+	return token, r.PostForm.Get("token_type_hint"), nil
+}
+
+// Hint only changes the order in which token types are tried, unknown hints are ignored
+func (c *AuthController) tokenTypesByHint(hint string) []string {
+	if hint == tokenTypeRefreshToken {
+		return []string{tokenTypeRefreshToken, tokenTypeAccessToken}
+	}
+	return []string{tokenTypeAccessToken, tokenTypeRefreshToken}
+}
+
+func (c *AuthController) introspectAccessToken(token string) (*auth.IntrospectionResponse, error) {
+	accessPayload, err := c.getActiveAccessToken(token)
+	if err != nil {
+		if errors.Is(err, errInactiveToken) {
+			return &auth.IntrospectionResponse{Active: false}, nil
+		}
+		return nil, err
+	}
+
+	return &auth.IntrospectionResponse{
+		Active:    true,
+		Scope:     accessPayload.Scope,
+		TokenType: tokenTypeAccessToken,
+		Exp:       accessPayload.Exp,
+		Iat:       accessPayload.Iat,
+		Nbf:       accessPayload.Nbf,
+		Sub:       accessPayload.Sub.String(),
+		Aud:       accessPayload.Aud,
+		Iss:       accessPayload.Iss,
+		Jti:       accessPayload.Jti.String(),
+		Sid:       accessPayload.Sid.String(),
+	}, nil
+}
+
+func (c *AuthController) introspectRefreshToken(token string) (*auth.IntrospectionResponse, error) {
+	refreshToken, err := c.getActiveRefreshToken(token)
+	if err != nil {
+		if errors.Is(err, errInactiveToken) {
+			return &auth.IntrospectionResponse{Active: false}, nil
+		}
+		return nil, err
+	}
+
+	return &auth.IntrospectionResponse{
+		Active:    true,
+		Scope:     refreshToken.Scope,
+		TokenType: tokenTypeRefreshToken,
+		Iat:       refreshToken.CreatedAt.Unix(),
+		Sub:       refreshToken.UserUUID.String(),
+		Jti:       refreshToken.UUID.String(),
+		Sid:       refreshToken.FamilyUUID.String(),
+	}, nil
+}
+
+// Access token is active while it is valid, neither it nor its session has been revoked,
+// and the password hasn't been changed since it was issued
+func (c *AuthController) getActiveAccessToken(token string) (*auth.AccessPayload, error) {
+	accessPayload, err := c.jwtService.GetAccessTokenPayload(token)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errInactiveToken, err)
+	}
+
+	isRevoked, err := c.denylist.Contains(accessPayload.Jti)
+	if err != nil {
+		return nil, err
+	}
+	if isRevoked {
+		return nil, errInactiveToken
+	}
+
+	_, err = c.service.GetActiveRefreshTokenByFamily(accessPayload.Sid)
+	if err != nil {
+		if errors.Is(err, common.ErrRefreshTokenNotFound) {
+			return nil, fmt.Errorf("%w: %w", errInactiveToken, err)
+		}
+		return nil, err
+	}
+
+	user, err := c.service.GetUser(accessPayload.Sub)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errInactiveToken, err)
+	}
+	if c.isIssuedBeforePasswordChange(user, accessPayload.Iat) {
+		return nil, fmt.Errorf("%w: %w", errInactiveToken, errIssuedBeforePasswordChange)
+	}
+
+	return accessPayload, nil
+}
+
+// Refresh token is active until it is rotated or its session is revoked
+func (c *AuthController) getActiveRefreshToken(token string) (*auth.RefreshToken, error) {
+	refreshPayload, err := c.jwtService.GetRefreshTokenPayload(token)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errInactiveToken, err)
+	}
+
+	refreshToken, err := c.service.GetRefreshToken(refreshPayload.Jti)
+	if err != nil {
+		if errors.Is(err, common.ErrRefreshTokenNotFound) {
+			return nil, fmt.Errorf("%w: %w", errInactiveToken, err)
+		}
+		return nil, err
+	}
+
+	if !refreshToken.Active {
+		return nil, errInactiveToken
+	}
+
+	if err = c.compareRefreshTokenSecret(refreshToken, refreshPayload); err != nil {
+		return nil, fmt.Errorf("%w: %w", errInactiveToken, err)
+	}
+
+	return refreshToken, nil
+}
@@ -0,0 +1,318 @@
+package chi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	auth "github.com/medods-technical-assessment"
+	"github.com/medods-technical-assessment/internal/common"
+)
+
+// Matches the timeout the client is given by the options
+const webAuthnSessionExpireTime = 5 * time.Minute
+
+var errInvalidPasskey = errors.New("invalid passkey")
+
+// Starts passkey registration, returning options for `navigator.credentials.create()`
+func (c *AuthController) BeginWebAuthnRegistration(w http.ResponseWriter, r *http.Request) {
+	principal, err := GetPrincipal(r.Context())
+	if err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+
+	var registrationInput auth.BeginWebAuthnRegistrationDto
+	if err := decoder.Decode(&registrationInput); err != nil {
+		BadRequestErrorHandler(w, err)
+		return
+	}
+
+	if errors := c.validationService.ValidateUserInput(registrationInput); len(errors) > 0 {
+		ValidationErrorHandler(w, errors)
+		return
+	}
+
+	user, err := c.service.GetUser(principal.UserUUID)
+	if err != nil {
+		NotFoundErrorHandler(w, err)
+		return
+	}
+
+	// Shares the limit with password attempts of the account, same as ChangePassword
+	if !c.reserveLoginAttempt(w, r, user.Email) || !c.checkAccountLock(w, user) {
+		return
+	}
+
+	if err = c.cryptoService.ComparePasswords(user.Password, registrationInput.Password); err != nil {
+		c.countFailedLogin(r, user)
+		ForbiddenErrorHandler(w, fmt.Errorf("password is incorrect"))
+		return
+	}
+	c.releaseLoginAttempt(r, user.Email)
+
+	credentials, err := c.service.GetWebAuthnCredentialsByUser(user.UUID)
+	if err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+
+	options, sessionData, err := c.webAuthnService.BeginRegistration(user, credentials)
+	if err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+
+	c.writeWebAuthnOptions(w, &user.UUID, auth.WebAuthnCeremonyRegistration, options, sessionData)
+}
+
+// Verifies the new credential and adds it to the user's passkeys
+func (c *AuthController) FinishWebAuthnRegistration(w http.ResponseWriter, r *http.Request) {
+	principal, err := GetPrincipal(r.Context())
+	if err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+
+	var registrationInput auth.FinishWebAuthnRegistrationDto
+	if err := decoder.Decode(&registrationInput); err != nil {
+		BadRequestErrorHandler(w, err)
+		return
+	}
+
+	if errors := c.validationService.ValidateUserInput(registrationInput); len(errors) > 0 {
+		ValidationErrorHandler(w, errors)
+		return
+	}
+
+	session, err := c.consumeWebAuthnSession(registrationInput.CeremonyID, auth.WebAuthnCeremonyRegistration)
+	if err != nil {
+		if errors.Is(err, common.ErrWebAuthnSessionNotFound) {
+			ForbiddenErrorHandler(w, err)
+			return
+		}
+		InternalErrorHandler(w, err)
+		return
+	}
+	// Ceremony can only be finished by the user who started it
+	if session.UserUUID == nil || *session.UserUUID != principal.UserUUID {
+		ForbiddenErrorHandler(w, common.ErrWebAuthnSessionNotFound)
+		return
+	}
+
+	user, err := c.service.GetUser(principal.UserUUID)
+	if err != nil {
+		NotFoundErrorHandler(w, err)
+		return
+	}
+
+	credentials, err := c.service.GetWebAuthnCredentialsByUser(user.UUID)
+	if err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+
+	credential, err := c.webAuthnService.FinishRegistration(user, credentials, session.Data, registrationInput.Credential)
+	if err != nil {
+		BadRequestErrorHandler(w, err)
+		return
+	}
+
+	if err = c.service.AddWebAuthnCredential(credential); err != nil {
+		if errors.Is(err, common.ErrDuplicateWebAuthnCredential) {
+			ConflictErrorHandler(w, err)
+			return
+		}
+		InternalErrorHandler(w, err)
+		return
+	}
+
+	c.recordSecurityEvent(r, user.UUID, auth.SecurityEventPasskeyAdded, fmt.Sprintf("passkey %s was registered", credential.ID))
+	ipStr, _ := c.getIp(r)
+	c.mailService.Send(user.Email, "Passkey added", fmt.Sprintf(`A passkey was added to your account from ip address %s, it can now be used to log in without a password. If you didn't do this, please remove it and reset your password.`, ipStr))
+
+	if err = c.writeResponse(respParams{w: w, code: http.StatusCreated, json: credential}); err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+}
+
+func (c *AuthController) GetWebAuthnCredentials(w http.ResponseWriter, r *http.Request) {
+	principal, err := GetPrincipal(r.Context())
+	if err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+
+	credentials, err := c.service.GetWebAuthnCredentialsByUser(principal.UserUUID)
+	if err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+
+	if err = c.writeResponse(respParams{w: w, code: http.StatusOK, json: credentials}); err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+}
+
+// Removes the passkey, e.g. when the device is lost. The authenticator keeps it, but it can't
+// be used to log in anymore
+func (c *AuthController) DeleteWebAuthnCredential(w http.ResponseWriter, r *http.Request) {
+	principal, err := GetPrincipal(r.Context())
+	if err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+
+	credentialID := chi.URLParam(r, "CredentialID")
+	if err = c.service.DeleteWebAuthnCredential(principal.UserUUID, credentialID); err != nil {
+		if errors.Is(err, common.ErrWebAuthnCredentialNotFound) {
+			NotFoundErrorHandler(w, err)
+			return
+		}
+		InternalErrorHandler(w, err)
+		return
+	}
+
+	c.recordSecurityEvent(r, principal.UserUUID, auth.SecurityEventPasskeyRemoved, fmt.Sprintf("passkey %s was removed", credentialID))
+	if user, err := c.service.GetUser(principal.UserUUID); err == nil {
+		ipStr, _ := c.getIp(r)
+		c.mailService.Send(user.Email, "Passkey removed", fmt.Sprintf(`A passkey was removed from your account from ip address %s. If you didn't do this, please reset your password.`, ipStr))
+	}
+
+	if err = c.writeResponse(respParams{w: w, code: http.StatusNoContent}); err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+}
+
+// Starts passwordless login, returning options for `navigator.credentials.get()`. The user
+// isn't asked for their email, the authenticator offers the passkeys it has for the service
+func (c *AuthController) BeginWebAuthnLogin(w http.ResponseWriter, r *http.Request) {
+	options, sessionData, err := c.webAuthnService.BeginLogin()
+	if err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+
+	c.writeWebAuthnOptions(w, nil, auth.WebAuthnCeremonyLogin, options, sessionData)
+}
+
+// Verifies the assertion and issues tokens. Passkeys require user verification, i.e. they are
+// something the user has, unlocked by something they know or are, so no second factor is asked for
+func (c *AuthController) FinishWebAuthnLogin(w http.ResponseWriter, r *http.Request) {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+
+	var loginInput auth.FinishWebAuthnLoginDto
+	if err := decoder.Decode(&loginInput); err != nil {
+		BadRequestErrorHandler(w, err)
+		return
+	}
+
+	if errors := c.validationService.ValidateUserInput(loginInput); len(errors) > 0 {
+		ValidationErrorHandler(w, errors)
+		return
+	}
+
+	scope, err := c.narrowScope(loginInput.Scope, auth.Scopes)
+	if err != nil {
+		BadRequestErrorHandler(w, err)
+		return
+	}
+
+	session, err := c.consumeWebAuthnSession(loginInput.CeremonyID, auth.WebAuthnCeremonyLogin)
+	if err != nil {
+		if errors.Is(err, common.ErrWebAuthnSessionNotFound) {
+			ForbiddenErrorHandler(w, err)
+			return
+		}
+		InternalErrorHandler(w, err)
+		return
+	}
+
+	user, credential, err := c.webAuthnService.FinishLogin(session.Data, loginInput.Credential, func(userUUID auth.UUID) (*auth.User, []*auth.WebAuthnCredential, error) {
+		user, err := c.service.GetUser(userUUID)
+		if err != nil {
+			return nil, nil, err
+		}
+		credentials, err := c.service.GetWebAuthnCredentialsByUser(userUUID)
+		if err != nil {
+			return nil, nil, err
+		}
+		return user, credentials, nil
+	})
+	if err != nil {
+		ForbiddenErrorHandler(w, fmt.Errorf("%w: %w", errInvalidPasskey, err))
+		return
+	}
+
+	// Counter of a cloned authenticator falls behind the original, so whichever copy is used
+	// second is rejected, and the user is told to replace the passkey
+	if credential.CloneWarning {
+		c.recordSecurityEvent(r, user.UUID, auth.SecurityEventPasskeyCloneWarning, fmt.Sprintf("signature counter of passkey %s hasn't increased, login was rejected", credential.ID))
+		ipStr, _ := c.getIp(r)
+		c.mailService.Send(user.Email, "Passkey may have been copied", fmt.Sprintf(`A login with one of your passkeys from ip address %s was rejected, as the passkey may have been copied. Please remove it and add a new one.`, ipStr))
+		ForbiddenErrorHandler(w, fmt.Errorf("%w: authenticator may have been cloned", errInvalidPasskey))
+		return
+	}
+
+	if err = c.service.UpdateWebAuthnCredentialUsage(credential); err != nil {
+		// Passkey was removed while the ceremony was running
+		if errors.Is(err, common.ErrWebAuthnCredentialNotFound) {
+			ForbiddenErrorHandler(w, fmt.Errorf("%w: %w", errInvalidPasskey, err))
+			return
+		}
+		InternalErrorHandler(w, err)
+		return
+	}
+
+	c.handleSuccessfulAuth(w, r, user, scope)
+}
+
+// Keeps ceremony state until the finish step, responding with the options and the ceremony id
+func (c *AuthController) writeWebAuthnOptions(w http.ResponseWriter, userUUID *auth.UUID, ceremony auth.WebAuthnCeremony, options any, sessionData []byte) {
+	createdAt := time.Now()
+	session := &auth.WebAuthnSession{
+		UUID:      c.uuidService.New(),
+		UserUUID:  userUUID,
+		Ceremony:  ceremony,
+		Data:      sessionData,
+		ExpiresAt: createdAt.Add(webAuthnSessionExpireTime),
+		CreatedAt: createdAt,
+	}
+	if err := c.service.AddWebAuthnSession(session); err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+
+	webAuthnOptions := &auth.WebAuthnOptions{
+		CeremonyID: session.UUID,
+		Options:    options,
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	if err := c.writeResponse(respParams{w: w, code: http.StatusOK, json: webAuthnOptions}); err != nil {
+		InternalErrorHandler(w, err)
+		return
+	}
+}
+
+func (c *AuthController) consumeWebAuthnSession(ceremonyID string, ceremony auth.WebAuthnCeremony) (*auth.WebAuthnSession, error) {
+	sessionUUID, err := c.uuidService.Parse(ceremonyID)
+	if err != nil {
+		return nil, common.ErrWebAuthnSessionNotFound
+	}
+
+	return c.service.ConsumeWebAuthnSession(sessionUUID, ceremony)
+}
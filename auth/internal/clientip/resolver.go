@@ -0,0 +1,172 @@
+package clientip
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Header the proxies in front of the service put the client address into
+type Header string
+
+const (
+	// ref: https://datatracker.ietf.org/doc/html/rfc7239
+	HeaderForwarded     Header = "Forwarded"
+	HeaderXForwardedFor Header = "X-Forwarded-For"
+	// Has to be overwritten by the proxy rather than appended to
+	HeaderXRealIP Header = "X-Real-IP"
+)
+
+// Resolver represents an implementation of auth.ClientIPResolver, which only believes
+// headers added by trusted proxies. Only the header the proxies set is read, as clients
+// can send any of them
+// ref: https://adam-p.ca/blog/2022/03/x-forwarded-for/
+type Resolver struct {
+	header         Header
+	trustedProxies []netip.Prefix
+}
+
+func NewResolver(header Header, trustedProxies []netip.Prefix) *Resolver {
+	return &Resolver{
+		header:         header,
+		trustedProxies: trustedProxies,
+	}
+}
+
+// Parses comma separated CIDRs or single addresses, e.g. `10.0.0.0/8, 192.0.2.1`
+func ParseTrustedProxies(proxiesStr string) ([]netip.Prefix, error) {
+	proxies := make([]netip.Prefix, 0)
+	for _, proxy := range strings.Split(proxiesStr, ",") {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+
+		if strings.Contains(proxy, "/") {
+			prefix, err := netip.ParsePrefix(proxy)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %s: %w", proxy, err)
+			}
+			proxies = append(proxies, prefix.Masked())
+			continue
+		}
+
+		addr, err := netip.ParseAddr(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %s: %w", proxy, err)
+		}
+		addr = addr.Unmap()
+		proxies = append(proxies, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+
+	return proxies, nil
+}
+
+func ParseHeader(headerStr string) (Header, error) {
+	switch {
+	case strings.EqualFold(headerStr, string(HeaderForwarded)):
+		return HeaderForwarded, nil
+	case strings.EqualFold(headerStr, string(HeaderXForwardedFor)):
+		return HeaderXForwardedFor, nil
+	case strings.EqualFold(headerStr, string(HeaderXRealIP)):
+		return HeaderXRealIP, nil
+	default:
+		return "", fmt.Errorf("invalid client ip header %s: must be one of Forwarded, X-Forwarded-For or X-Real-IP", headerStr)
+	}
+}
+
+// Walks the proxy chain from the peer towards the client, returning the first address which isn't
+// a trusted proxy. Addresses to the left of it could have been made up by the client
+func (res *Resolver) ClientIP(r *http.Request) netip.Addr {
+	peer := parseHost(r.RemoteAddr)
+	if !peer.IsValid() || !res.isTrusted(peer) {
+		return peer
+	}
+
+	var hops []string
+	switch res.header {
+	case HeaderForwarded:
+		hops = forwardedFor(r.Header.Values("Forwarded"))
+	case HeaderXForwardedFor:
+		hops = splitList(r.Header.Values("X-Forwarded-For"))
+	case HeaderXRealIP:
+		hops = splitList(r.Header.Values("X-Real-IP"))
+		// Several values mean the header was appended to, so it can't be trusted
+		if len(hops) > 1 {
+			return peer
+		}
+	}
+
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := parseHost(hops[i])
+		// Hidden or malformed address, the last known hop is the best guess
+		if !hop.IsValid() {
+			return client
+		}
+
+		client = hop
+		if !res.isTrusted(hop) {
+			return client
+		}
+	}
+
+	return client
+}
+
+func (res *Resolver) isTrusted(addr netip.Addr) bool {
+	for _, proxy := range res.trustedProxies {
+		if proxy.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns `for` parameters of the elements, in order. Elements without it are kept empty,
+// so that they are treated as unknown hops
+func forwardedFor(values []string) []string {
+	hops := make([]string, 0)
+	for _, element := range splitList(values) {
+		hop := ""
+		for _, pair := range strings.Split(element, ";") {
+			name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if ok && strings.EqualFold(name, "for") {
+				hop = strings.Trim(value, `"`)
+			}
+		}
+		hops = append(hops, hop)
+	}
+
+	return hops
+}
+
+// Joins repeated headers, which is equivalent to a single comma separated one
+func splitList(values []string) []string {
+	items := make([]string, 0)
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			items = append(items, strings.TrimSpace(item))
+		}
+	}
+
+	return items
+}
+
+// Accepts an address with or without port, IPv6 with or without brackets
+func parseHost(hostStr string) netip.Addr {
+	if host, _, err := net.SplitHostPort(hostStr); err == nil {
+		hostStr = host
+	}
+	hostStr = strings.TrimSuffix(strings.TrimPrefix(hostStr, "["), "]")
+
+	addr, err := netip.ParseAddr(hostStr)
+	if err != nil {
+		return netip.Addr{}
+	}
+
+	return addr.Unmap()
+}
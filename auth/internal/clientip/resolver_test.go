@@ -0,0 +1,96 @@
+package clientip
+
+import (
+	"net/http"
+	"net/netip"
+	"testing"
+)
+
+func TestResolverClientIP(t *testing.T) {
+	trustedProxies, err := ParseTrustedProxies("10.0.0.0/8, 2001:db8:ffff::/48, 192.0.2.254")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name       string
+		header     Header
+		remoteAddr string
+		headers    map[string][]string
+		want       string
+	}{
+		{"Direct client", HeaderXForwardedFor, "203.0.113.7:4711", nil, "203.0.113.7"},
+		{"Headers of untrusted peer are ignored", HeaderXForwardedFor, "203.0.113.7:4711", map[string][]string{"X-Forwarded-For": {"198.51.100.1"}}, "203.0.113.7"},
+		{"Trusted proxy without header", HeaderXForwardedFor, "10.0.0.1:4711", nil, "10.0.0.1"},
+		{"X-Forwarded-For", HeaderXForwardedFor, "10.0.0.1:4711", map[string][]string{"X-Forwarded-For": {"203.0.113.7"}}, "203.0.113.7"},
+		{"X-Forwarded-For spoofed by client", HeaderXForwardedFor, "10.0.0.1:4711", map[string][]string{"X-Forwarded-For": {"198.51.100.1, 203.0.113.7"}}, "203.0.113.7"},
+		{"X-Forwarded-For through several proxies", HeaderXForwardedFor, "10.0.0.1:4711", map[string][]string{"X-Forwarded-For": {"198.51.100.1, 203.0.113.7, 10.0.0.2, 192.0.2.254"}}, "203.0.113.7"},
+		{"X-Forwarded-For in repeated headers", HeaderXForwardedFor, "10.0.0.1:4711", map[string][]string{"X-Forwarded-For": {"198.51.100.1", "203.0.113.7, 10.0.0.2"}}, "203.0.113.7"},
+		{"X-Forwarded-For of trusted proxies only", HeaderXForwardedFor, "10.0.0.1:4711", map[string][]string{"X-Forwarded-For": {"10.0.0.3, 10.0.0.2"}}, "10.0.0.3"},
+		{"X-Forwarded-For with malformed hop", HeaderXForwardedFor, "10.0.0.1:4711", map[string][]string{"X-Forwarded-For": {"203.0.113.7, garbage, 10.0.0.2"}}, "10.0.0.2"},
+		{"X-Forwarded-For with port", HeaderXForwardedFor, "10.0.0.1:4711", map[string][]string{"X-Forwarded-For": {"203.0.113.7:8080"}}, "203.0.113.7"},
+		{"X-Forwarded-For ignored when Forwarded is configured", HeaderForwarded, "10.0.0.1:4711", map[string][]string{"X-Forwarded-For": {"198.51.100.1"}}, "10.0.0.1"},
+		{"IPv6 proxy", HeaderXForwardedFor, "[2001:db8:ffff::1]:4711", map[string][]string{"X-Forwarded-For": {"2001:db8:1::7"}}, "2001:db8:1::7"},
+		{"IPv4-mapped IPv6 peer", HeaderXForwardedFor, "[::ffff:10.0.0.1]:4711", map[string][]string{"X-Forwarded-For": {"203.0.113.7"}}, "203.0.113.7"},
+		{"Forwarded", HeaderForwarded, "10.0.0.1:4711", map[string][]string{"Forwarded": {"for=203.0.113.7;proto=https"}}, "203.0.113.7"},
+		{"Forwarded with quoted IPv6 and port", HeaderForwarded, "10.0.0.1:4711", map[string][]string{"Forwarded": {`for="[2001:db8:1::7]:4711"`}}, "2001:db8:1::7"},
+		{"Forwarded spoofed by client", HeaderForwarded, "10.0.0.1:4711", map[string][]string{"Forwarded": {"for=198.51.100.1, For=203.0.113.7;by=10.0.0.1"}}, "203.0.113.7"},
+		{"Forwarded through several proxies", HeaderForwarded, "10.0.0.1:4711", map[string][]string{"Forwarded": {"for=203.0.113.7", "for=10.0.0.2"}}, "203.0.113.7"},
+		{"Forwarded with obfuscated hop", HeaderForwarded, "10.0.0.1:4711", map[string][]string{"Forwarded": {"for=_hidden, for=10.0.0.2"}}, "10.0.0.2"},
+		{"Forwarded element without for", HeaderForwarded, "10.0.0.1:4711", map[string][]string{"Forwarded": {"proto=https"}}, "10.0.0.1"},
+		{"X-Real-IP", HeaderXRealIP, "10.0.0.1:4711", map[string][]string{"X-Real-IP": {"203.0.113.7"}}, "203.0.113.7"},
+		{"X-Real-IP appended to", HeaderXRealIP, "10.0.0.1:4711", map[string][]string{"X-Real-IP": {"198.51.100.1", "203.0.113.7"}}, "10.0.0.1"},
+		{"Malformed remote address", HeaderXForwardedFor, "garbage", map[string][]string{"X-Forwarded-For": {"203.0.113.7"}}, "invalid IP"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &http.Request{RemoteAddr: tt.remoteAddr, Header: http.Header{}}
+			for name, values := range tt.headers {
+				for _, value := range values {
+					r.Header.Add(name, value)
+				}
+			}
+
+			got := NewResolver(tt.header, trustedProxies).ClientIP(r)
+
+			if got.String() != tt.want {
+				t.Errorf("got ip %v, want ip %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	var tests = []struct {
+		name      string
+		input     string
+		want      []netip.Prefix
+		wantValid bool
+	}{
+		{"Empty", "", []netip.Prefix{}, true},
+		{"CIDRs and addresses", "10.0.0.1/8, 192.0.2.1,2001:db8::1", []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("192.0.2.1/32"), netip.MustParsePrefix("2001:db8::1/128")}, true},
+		{"Invalid CIDR", "10.0.0.0/33", nil, false},
+		{"Invalid address", "proxy.local", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseTrustedProxies(tt.input)
+			isValid := err == nil
+
+			if isValid != tt.wantValid {
+				t.Errorf("got valid %v, want valid %v", isValid, tt.wantValid)
+				return
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got proxies %v, want proxies %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("got proxies %v, want proxies %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,7 @@
+package common
+
+import "fmt"
+
+var (
+	ErrAccessDenied = fmt.Errorf("access denied")
+)
@@ -3,9 +3,20 @@ package common
 import "fmt"
 
 var (
-	ErrDuplicateEmail = fmt.Errorf("user with this email already exists")
+	ErrDuplicateEmail              = fmt.Errorf("user with this email already exists")
+	ErrRefreshTokenFamilyNotFound  = fmt.Errorf("active refresh token family not found")
+	ErrRefreshTokenNotFound        = fmt.Errorf("refresh token not found")
+	ErrRefreshTokenNotActive       = fmt.Errorf("refresh token has already been rotated or revoked")
+	ErrOneTimeTokenNotFound        = fmt.Errorf("one-time token not found, expired or already used")
+	ErrTOTPCredentialNotFound      = fmt.Errorf("totp credential not found")
+	ErrTOTPStepUsed                = fmt.Errorf("totp code has already been used")
+	ErrRecoveryCodeNotFound        = fmt.Errorf("recovery code not found or already used")
+	ErrWebAuthnSessionNotFound     = fmt.Errorf("webauthn ceremony not found, expired or already finished")
+	ErrWebAuthnCredentialNotFound  = fmt.Errorf("passkey not found")
+	ErrDuplicateWebAuthnCredential = fmt.Errorf("passkey is already registered")
 )
 
 const (
-	ConstraintUserEmailUnique = "users_email_unique"
+	ConstraintUserEmailUnique        = "users_email_unique"
+	ConstraintWebAuthnCredentialPKey = "webauthn_credentials_pkey"
 )
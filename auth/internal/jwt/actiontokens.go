@@ -0,0 +1,98 @@
+package jwt
+
+import (
+	"fmt"
+
+	auth "github.com/medods-technical-assessment"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Action tokens are signed with the same keys as access tokens, but can't be mistaken for them:
+// they have their own type and are intended for the issuer itself rather than for the access token audience,
+// and their purpose is checked when they are parsed
+func (j *JWTService) GenerateActionToken(payload *auth.ActionPayload) (string, error) {
+	mapClaims := jwt.MapClaims{
+		"jti":     payload.Jti,
+		"purpose": payload.Purpose,
+		"sub":     payload.Sub,
+		"email":   payload.Email,
+		"iat":     payload.Iat,
+		"exp":     payload.Exp,
+	}
+	if payload.Scope != "" {
+		mapClaims["scope"] = payload.Scope
+	}
+	if j.issuer != "" {
+		mapClaims["iss"] = j.issuer
+		mapClaims["aud"] = []string{j.issuer}
+	}
+
+	key := j.keys.Active()
+	token := jwt.NewWithClaims(key.Method, mapClaims)
+	token.Header["kid"] = key.Kid
+	token.Header["typ"] = actionTokenType
+
+	return token.SignedString(key.signKey)
+}
+
+func (j *JWTService) GetActionTokenPayload(actionToken string, purpose auth.ActionPurpose) (*auth.ActionPayload, error) {
+	claims, err := j.parseToken(actionToken, actionTokenType, j.issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	payload := &auth.ActionPayload{}
+
+	if purposeStr, ok := claims["purpose"].(string); ok && auth.ActionPurpose(purposeStr) == purpose {
+		payload.Purpose = purpose
+	} else {
+		return nil, fmt.Errorf("invalid purpose claim")
+	}
+
+	if jtiStr, ok := claims["jti"].(string); ok {
+		jti, err := j.uuidService.Parse(jtiStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid jti claim type")
+		}
+		payload.Jti = jti
+	} else {
+		return nil, fmt.Errorf("invalid jti claim type")
+	}
+
+	if subStr, ok := claims["sub"].(string); ok {
+		sub, err := j.uuidService.Parse(subStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sub claim type")
+		}
+		payload.Sub = sub
+	} else {
+		return nil, fmt.Errorf("invalid sub claim type")
+	}
+
+	if email, ok := claims["email"].(string); ok {
+		payload.Email = email
+	} else {
+		return nil, fmt.Errorf("invalid email claim type")
+	}
+
+	if scope, ok := claims["scope"]; ok {
+		if payload.Scope, ok = scope.(string); !ok {
+			return nil, fmt.Errorf("invalid scope claim type")
+		}
+	}
+
+	if iat, ok := claims["iat"].(float64); ok {
+		payload.Iat = int64(iat)
+	} else {
+		return nil, fmt.Errorf("invalid iat claim type")
+	}
+
+	if exp, ok := claims["exp"].(float64); ok {
+		payload.Exp = int64(exp)
+	} else {
+		return nil, fmt.Errorf("invalid exp claim type")
+	}
+
+	return payload, nil
+}
@@ -0,0 +1,87 @@
+package jwt
+
+import (
+	"testing"
+	"time"
+
+	auth "github.com/medods-technical-assessment"
+	"github.com/medods-technical-assessment/internal/uuid"
+)
+
+func TestJWTServiceActionTokens(t *testing.T) {
+	us := uuid.NewUUIDService()
+	js := NewJWTService("MTIzNA==", us, WithIssuer("auth"), WithAudience("auth"))
+	otherJs := NewJWTService("NTY3OA==", us, WithIssuer("auth"), WithAudience("auth"))
+
+	newActionToken := func(js *JWTService, purpose auth.ActionPurpose, expiresIn time.Duration) string {
+		issuedAt := time.Now()
+		token, err := js.GenerateActionToken(&auth.ActionPayload{Jti: us.New(), Purpose: purpose, Sub: us.New(), Email: "email@example.com", Iat: issuedAt.Unix(), Exp: issuedAt.Add(expiresIn).Unix()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	var tests = []struct {
+		name      string
+		input     string
+		wantValid bool
+	}{
+		{"Valid token", newActionToken(js, auth.ActionPurposeVerifyEmail, time.Hour), true},
+		{"Expired token", newActionToken(js, auth.ActionPurposeVerifyEmail, -time.Hour), false},
+		{"Token with other purpose", newActionToken(js, auth.ActionPurpose("other"), time.Hour), false},
+		{"Token signed with other key", newActionToken(otherJs, auth.ActionPurposeVerifyEmail, time.Hour), false},
+		{"Access token", generateAccessToken(t, js), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, err := js.GetActionTokenPayload(tt.input, auth.ActionPurposeVerifyEmail)
+			isValid := err == nil
+
+			if isValid != tt.wantValid {
+				t.Errorf("got valid %v, want valid %v (%v)", isValid, tt.wantValid, err)
+				return
+			}
+			if isValid && payload.Email != "email@example.com" {
+				t.Errorf("got email %v, want email %v", payload.Email, "email@example.com")
+			}
+		})
+	}
+
+	// Action token must not be accepted in place of an access token
+	if err := js.VerifyAccessToken(newActionToken(js, auth.ActionPurposeVerifyEmail, time.Hour)); err == nil {
+		t.Errorf("got action token verified as access token, want error")
+	}
+}
+
+func TestJWTServiceActionTokenScope(t *testing.T) {
+	us := uuid.NewUUIDService()
+	js := NewJWTService("MTIzNA==", us)
+
+	var tests = []struct {
+		name  string
+		scope string
+	}{
+		{"Token with scope", "users:read sessions:manage"},
+		{"Token without scope", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issuedAt := time.Now()
+			token, err := js.GenerateActionToken(&auth.ActionPayload{Jti: us.New(), Purpose: auth.ActionPurposeMFA, Sub: us.New(), Email: "email@example.com", Scope: tt.scope, Iat: issuedAt.Unix(), Exp: issuedAt.Add(time.Minute).Unix()})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			payload, err := js.GetActionTokenPayload(token, auth.ActionPurposeMFA)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if payload.Scope != tt.scope {
+				t.Errorf("got scope %v, want scope %v", payload.Scope, tt.scope)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package jwt
 
 import (
+	"crypto/rand"
 	"encoding/base64"
 	"fmt"
 	"log"
@@ -12,11 +13,41 @@ import (
 )
 
 type JWTService struct {
-	uuidService  auth.UUIDService
-	accessSecret []byte
+	uuidService auth.UUIDService
+	keys        *KeyRing
+	// Issuer and audience written into access tokens
+	issuer   string
+	audience []string
+	// Audience access token must be intended for to be accepted
+	expectedAudience string
 }
 
-func NewJWTService(accessSecretStr string, uuidService auth.UUIDService) *JWTService {
+type Option func(*JWTService)
+
+// Sets `iss` claim of issued tokens, and requires it to match when verifying them
+func WithIssuer(issuer string) Option {
+	return func(j *JWTService) {
+		j.issuer = issuer
+	}
+}
+
+// Sets `aud` claim of issued tokens. Unless WithExpectedAudience is given, verified tokens
+// are required to be intended for the first of the audiences
+func WithAudience(audience ...string) Option {
+	return func(j *JWTService) {
+		j.audience = audience
+	}
+}
+
+// Requires verified tokens to have the audience in their `aud` claim
+func WithExpectedAudience(audience string) Option {
+	return func(j *JWTService) {
+		j.expectedAudience = audience
+	}
+}
+
+// Creates service signing access tokens with HMAC-SHA512 using the shared secret
+func NewJWTService(accessSecretStr string, uuidService auth.UUIDService, opts ...Option) *JWTService {
 
 	if accessSecretStr == "" {
 		log.Panic(fmt.Errorf("error creating jwt service: accessSecretStr is empty"))
@@ -28,18 +59,65 @@ func NewJWTService(accessSecretStr string, uuidService auth.UUIDService) *JWTSer
 		log.Panic(fmt.Errorf("error creating jwt service: couldn't convert accessSecret into bytes: %w", err))
 	}
 
-	return &JWTService{
-		accessSecret: accessSecret,
-		uuidService:  uuidService,
+	return NewJWTServiceWithKey(NewHMACKey(accessSecret), uuidService, opts...)
+}
+
+// Creates service signing access tokens with the given key, e.g. an asymmetric one,
+// which lets other services verify tokens using the public JWKS without being able to mint them
+func NewJWTServiceWithKey(key *Key, uuidService auth.UUIDService, opts ...Option) *JWTService {
+	if key == nil {
+		log.Panic(fmt.Errorf("error creating jwt service: key is nil"))
+	}
+
+	return NewJWTServiceWithKeyRing(NewKeyRing(key), uuidService, opts...)
+}
+
+// Creates service signing access tokens with the active key of the ring, while accepting
+// tokens signed by any of its keys. Changes made to the ring take effect immediately
+func NewJWTServiceWithKeyRing(keys *KeyRing, uuidService auth.UUIDService, opts ...Option) *JWTService {
+	if keys == nil || keys.Active() == nil {
+		log.Panic(fmt.Errorf("error creating jwt service: key ring has no active key"))
+	}
+
+	j := &JWTService{
+		keys:        keys,
+		uuidService: uuidService,
+	}
+	for _, opt := range opts {
+		opt(j)
+	}
+	if j.expectedAudience == "" && len(j.audience) > 0 {
+		j.expectedAudience = j.audience[0]
 	}
+
+	return j
+}
+
+func (j *JWTService) KeyRing() *KeyRing {
+	return j.keys
 }
 
+// Issuer and audience of the access payload are filled in by the service, unless already set
 func (j *JWTService) GenerateTokens(refreshPayload *auth.RefreshPayload, accessPayload *auth.AccessPayload) (accessToken string, refreshToken string, err error) {
+	if accessPayload.Iss == "" {
+		accessPayload.Iss = j.issuer
+	}
+	if accessPayload.Aud == nil {
+		accessPayload.Aud = j.audience
+	}
+
 	accessToken, err = j.newAccessToken(accessPayload)
 	if err != nil {
 		return "", "", err
 	}
 
+	if refreshPayload.Secret == nil {
+		refreshPayload.Secret = make([]byte, refreshTokenSecretLength)
+		if _, err := rand.Read(refreshPayload.Secret); err != nil {
+			return "", "", fmt.Errorf("error generating refresh token secret: %w", err)
+		}
+	}
+
 	refreshToken = j.newRefreshToken(refreshPayload)
 
 	return accessToken, refreshToken, nil
@@ -48,42 +126,87 @@ func (j *JWTService) GenerateTokens(refreshPayload *auth.RefreshPayload, accessP
 func (j *JWTService) newAccessToken(payload *auth.AccessPayload) (string, error) {
 	mapClaims := jwt.MapClaims{
 		"jti": payload.Jti,
+		"sub": payload.Sub,
+		"sid": payload.Sid,
 		"ip":  payload.IP,
 		"iat": payload.Iat,
+		"nbf": payload.Nbf,
 		"exp": payload.Exp,
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS512, mapClaims)
-	tokenString, err := token.SignedString(j.accessSecret)
+	if payload.Iss != "" {
+		mapClaims["iss"] = payload.Iss
+	}
+	if len(payload.Aud) > 0 {
+		mapClaims["aud"] = payload.Aud
+	}
+	if payload.Scope != "" {
+		mapClaims["scope"] = payload.Scope
+	}
+	if len(payload.Roles) > 0 {
+		mapClaims["roles"] = payload.Roles
+	}
+	if len(payload.Permissions) > 0 {
+		mapClaims["permissions"] = payload.Permissions
+	}
+	key := j.keys.Active()
+	token := jwt.NewWithClaims(key.Method, mapClaims)
+	token.Header["kid"] = key.Kid
+	token.Header["typ"] = accessTokenType
+	tokenString, err := token.SignedString(key.signKey)
 
 	return tokenString, err
 
 }
 
+// Refresh token is jti, secret and ip concatenated
+const refreshTokenSecretLength = 32
+
 func (j *JWTService) newRefreshToken(payload *auth.RefreshPayload) string {
-	payloadJti := payload.Jti[:]
-	payloadIp := payload.IP.AsSlice()
-	payloadBytes := append(payloadJti, payloadIp...)
+	payloadBytes := make([]byte, 0, 16+len(payload.Secret)+16)
+	payloadBytes = append(payloadBytes, payload.Jti[:]...)
+	payloadBytes = append(payloadBytes, payload.Secret...)
+	payloadBytes = append(payloadBytes, payload.IP.AsSlice()...)
 
 	refreshToken := base64.StdEncoding.EncodeToString(payloadBytes)
 	return refreshToken
 }
 
 func (j *JWTService) VerifyAccessToken(accessToken string) error {
-	_, err := j.getAccessTokenPayload(accessToken, j.accessSecret)
+	_, err := j.getAccessTokenPayload(accessToken)
 	return err
 }
 
 func (j *JWTService) GetAccessTokenPayload(accessToken string) (*auth.AccessPayload, error) {
-	return j.getAccessTokenPayload(accessToken, j.accessSecret)
+	return j.getAccessTokenPayload(accessToken)
 }
 
-func (j *JWTService) getAccessTokenPayload(tokenString string, secret []byte) (*auth.AccessPayload, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (any, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return secret, nil
-	})
+func (j *JWTService) getAccessTokenPayload(tokenString string) (*auth.AccessPayload, error) {
+	claims, err := j.parseToken(tokenString, accessTokenType, j.expectedAudience)
+	if err != nil {
+		return nil, err
+	}
+
+	return j.parseAccessTokenClaims(claims)
+}
+
+// Types of tokens signed with the same keys, so that one can't be passed off as another
+const (
+	// ref: https://datatracker.ietf.org/doc/html/rfc9068#section-2.1
+	accessTokenType = "at+jwt"
+	actionTokenType = "action+jwt"
+)
+
+// Verifies signature, type and registered claims of the token
+func (j *JWTService) parseToken(tokenString string, tokenType string, audience string) (jwt.MapClaims, error) {
+	parserOpts := []jwt.ParserOption{jwt.WithIssuedAt()}
+	if j.issuer != "" {
+		parserOpts = append(parserOpts, jwt.WithIssuer(j.issuer))
+	}
+	if audience != "" {
+		parserOpts = append(parserOpts, jwt.WithAudience(audience))
+	}
+
+	token, err := jwt.Parse(tokenString, j.verificationKey, parserOpts...)
 
 	if err != nil {
 		return nil, err
@@ -93,12 +216,48 @@ func (j *JWTService) getAccessTokenPayload(tokenString string, secret []byte) (*
 		return nil, fmt.Errorf("invalid token")
 	}
 
+	if typ, _ := token.Header["typ"].(string); typ != tokenType {
+		return nil, fmt.Errorf("invalid token type: %v", token.Header["typ"])
+	}
+
 	claims, ok := token.Claims.(jwt.MapClaims)
 	if !ok {
 		return nil, fmt.Errorf("invalid token claims")
 	}
 
-	return j.parseAccessTokenClaims(claims)
+	return claims, nil
+}
+
+// Picks the key to verify the token with. Algorithm is dictated by the key rather than by
+// the token, so that e.g. a public key can't be used as an HMAC secret
+// ref: https://auth0.com/blog/critical-vulnerabilities-in-json-web-token-libraries/
+func (j *JWTService) verificationKey(token *jwt.Token) (any, error) {
+	// Tokens issued before kid header was introduced don't have it
+	key := j.keys.Active()
+	if kidHeader, ok := token.Header["kid"]; ok {
+		kid, _ := kidHeader.(string)
+		if key, ok = j.keys.Get(kid); !ok {
+			return nil, fmt.Errorf("unknown kid: %v", kidHeader)
+		}
+	}
+
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+
+	return key.verifyKey, nil
+}
+
+// Publishes both active and retired keys, since both are accepted
+func (j *JWTService) GetJWKS() *auth.JWKS {
+	jwks := &auth.JWKS{Keys: make([]*auth.JWK, 0)}
+	for _, key := range j.keys.Keys() {
+		if jwk := key.PublicJWK(); jwk != nil {
+			jwks.Keys = append(jwks.Keys, jwk)
+		}
+	}
+
+	return jwks
 }
 
 func (j *JWTService) parseAccessTokenClaims(claims jwt.MapClaims) (*auth.AccessPayload, error) {
@@ -114,6 +273,55 @@ func (j *JWTService) parseAccessTokenClaims(claims jwt.MapClaims) (*auth.AccessP
 		return nil, fmt.Errorf("invalid jti claim type")
 	}
 
+	if subStr, ok := claims["sub"].(string); ok {
+		sub, err := j.uuidService.Parse(subStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sub claim type")
+		}
+		payload.Sub = sub
+	} else {
+		return nil, fmt.Errorf("invalid sub claim type")
+	}
+
+	if sidStr, ok := claims["sid"].(string); ok {
+		sid, err := j.uuidService.Parse(sidStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sid claim type")
+		}
+		payload.Sid = sid
+	} else {
+		return nil, fmt.Errorf("invalid sid claim type")
+	}
+
+	// Optional, as tokens aren't restricted to specific scopes by default
+	if scope, ok := claims["scope"]; ok {
+		if payload.Scope, ok = scope.(string); !ok {
+			return nil, fmt.Errorf("invalid scope claim type")
+		}
+	}
+
+	// Optional, as users without roles have no permissions
+	var err error
+	if payload.Roles, err = parseStringsClaim(claims, "roles"); err != nil {
+		return nil, err
+	}
+	if payload.Permissions, err = parseStringsClaim(claims, "permissions"); err != nil {
+		return nil, err
+	}
+
+	// Presence of iss and aud is enforced by the parser, when they are expected
+	if iss, err := claims.GetIssuer(); err == nil {
+		payload.Iss = iss
+	} else {
+		return nil, fmt.Errorf("invalid iss claim type")
+	}
+
+	if aud, err := claims.GetAudience(); err == nil {
+		payload.Aud = aud
+	} else {
+		return nil, fmt.Errorf("invalid aud claim type")
+	}
+
 	if ip, ok := claims["ip"].(string); ok {
 		payload.IP = ip
 	} else {
@@ -132,9 +340,39 @@ func (j *JWTService) parseAccessTokenClaims(claims jwt.MapClaims) (*auth.AccessP
 		return nil, fmt.Errorf("invalid iat claim type")
 	}
 
+	if nbf, ok := claims["nbf"].(float64); ok {
+		payload.Nbf = int64(nbf)
+	} else {
+		return nil, fmt.Errorf("invalid nbf claim type")
+	}
+
 	return payload, nil
 }
 
+// Parses optional claim holding an array of strings
+func parseStringsClaim(claims jwt.MapClaims, name string) ([]string, error) {
+	claim, ok := claims[name]
+	if !ok {
+		return nil, nil
+	}
+
+	values, ok := claim.([]any)
+	if !ok {
+		return nil, fmt.Errorf("invalid %s claim type", name)
+	}
+
+	strs := make([]string, 0, len(values))
+	for _, value := range values {
+		str, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid %s claim type", name)
+		}
+		strs = append(strs, str)
+	}
+
+	return strs, nil
+}
+
 func (j *JWTService) GetRefreshTokenPayload(refreshToken string) (*auth.RefreshPayload, error) {
 	paySignCombined, err := base64.StdEncoding.DecodeString(refreshToken)
 	if err != nil {
@@ -145,6 +383,10 @@ func (j *JWTService) GetRefreshTokenPayload(refreshToken string) (*auth.RefreshP
 }
 
 func (j *JWTService) parseRefreshToken(payloadBytes []byte) (*auth.RefreshPayload, error) {
+	if len(payloadBytes) < 16+refreshTokenSecretLength {
+		return nil, fmt.Errorf("invalid refresh token length")
+	}
+
 	payloadJti := payloadBytes[:16]
 
 	jti, err := j.uuidService.FromBytes(payloadJti)
@@ -152,13 +394,14 @@ func (j *JWTService) parseRefreshToken(payloadBytes []byte) (*auth.RefreshPayloa
 		return nil, fmt.Errorf("invalid jti type: %w", err)
 	}
 
-	payloadIp := payloadBytes[16:]
+	secret := payloadBytes[16 : 16+refreshTokenSecretLength]
+	payloadIp := payloadBytes[16+refreshTokenSecretLength:]
 
 	ip, ok := netip.AddrFromSlice(payloadIp)
 	if !ok && len(payloadIp) != 0 {
 		return nil, fmt.Errorf("invalid ip type")
 	}
 
-	payload := &auth.RefreshPayload{Jti: jti, IP: ip}
+	payload := &auth.RefreshPayload{Jti: jti, IP: ip, Secret: secret}
 	return payload, nil
 }
@@ -1,9 +1,11 @@
 package jwt
 
 import (
+	"bytes"
 	"encoding/base64"
 	"fmt"
 	"net/netip"
+	"slices"
 	"strings"
 	"testing"
 	"time"
@@ -193,7 +195,7 @@ func TestJWTServiceGenerateTokens(t *testing.T) {
 
 			isValidAccessHeaderDec :=
 				strings.Contains(accessHeaderDecoded, "\"alg\":\"HS512\"") &&
-					strings.Contains(accessHeaderDecoded, "\"typ\":\"JWT\"")
+					strings.Contains(accessHeaderDecoded, "\"typ\":\"at+jwt\"")
 			if isValidAccessHeaderDec != tt.want.isValidAccessHeaderDec {
 				t.Errorf("got isValidAccessHeaderDec %v, want valid %v", isValidAccessHeaderDec, tt.want.isValidAccessHeaderDec)
 				return
@@ -242,6 +244,10 @@ func TestJWTServiceGenerateTokens(t *testing.T) {
 				t.Errorf("got isSameRefreshPayloadJti %v, want valid %v", isSameRefreshPayloadJti, tt.want.isSameRefreshPayloadJti)
 				return
 			}
+			if len(refreshPayload.Secret) != refreshTokenSecretLength || !bytes.Equal(refreshPayloadDecoded.Secret, refreshPayload.Secret) {
+				t.Errorf("got refresh token secret %x, want secret %x", refreshPayloadDecoded.Secret, refreshPayload.Secret)
+				return
+			}
 
 			err = js.VerifyAccessToken(accessToken)
 			isAccessTokenVerified := err == nil
@@ -253,3 +259,145 @@ func TestJWTServiceGenerateTokens(t *testing.T) {
 		})
 	}
 }
+
+func TestJWTServiceRegisteredClaims(t *testing.T) {
+	us := uuid.NewUUIDService()
+	type Input struct {
+		issuerOpts   []Option
+		verifierOpts []Option
+		notBefore    time.Duration
+	}
+
+	var tests = []struct {
+		name      string
+		input     *Input
+		wantValid bool
+	}{
+		{"Matching issuer and audience",
+			&Input{
+				issuerOpts:   []Option{WithIssuer("auth"), WithAudience("auth", "billing")},
+				verifierOpts: []Option{WithIssuer("auth"), WithAudience("auth", "billing")},
+			}, true,
+		},
+		{"Audience of another service",
+			&Input{
+				issuerOpts:   []Option{WithIssuer("auth"), WithAudience("auth", "billing")},
+				verifierOpts: []Option{WithIssuer("auth"), WithExpectedAudience("billing")},
+			}, true,
+		},
+		{"Not configured",
+			&Input{}, true,
+		},
+		{"Wrong issuer",
+			&Input{
+				issuerOpts:   []Option{WithIssuer("staging-auth"), WithAudience("auth")},
+				verifierOpts: []Option{WithIssuer("auth"), WithAudience("auth")},
+			}, false,
+		},
+		{"Wrong audience",
+			&Input{
+				issuerOpts:   []Option{WithIssuer("auth"), WithAudience("auth")},
+				verifierOpts: []Option{WithIssuer("auth"), WithExpectedAudience("billing")},
+			}, false,
+		},
+		{"Missing issuer and audience",
+			&Input{
+				verifierOpts: []Option{WithIssuer("auth"), WithAudience("auth")},
+			}, false,
+		},
+		{"Not yet valid",
+			&Input{
+				notBefore: time.Minute,
+			}, false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issuer := NewJWTService("MTIzNA==", us, tt.input.issuerOpts...)
+			verifier := NewJWTService("MTIzNA==", us, tt.input.verifierOpts...)
+
+			issuedAt := time.Now()
+			jti := us.New()
+			sub := us.New()
+			accessPayload := &auth.AccessPayload{Jti: jti, Sub: sub, IP: "127.0.0.1", Iat: issuedAt.Unix(), Nbf: issuedAt.Add(tt.input.notBefore).Unix(), Exp: issuedAt.Add(5 * time.Minute).Unix()}
+			accessToken, _, err := issuer.GenerateTokens(&auth.RefreshPayload{Jti: jti}, accessPayload)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			payload, err := verifier.GetAccessTokenPayload(accessToken)
+			isValid := err == nil
+			if isValid != tt.wantValid {
+				t.Errorf("got valid %v, want valid %v (%v)", isValid, tt.wantValid, err)
+				return
+			}
+
+			if isValid && payload.Sub != sub {
+				t.Errorf("got sub %v, want sub %v", payload.Sub, sub)
+			}
+		})
+	}
+}
+
+func TestJWTServiceGetRefreshTokenPayloadMalformed(t *testing.T) {
+	js := NewJWTService("MTIzNA==", uuid.NewUUIDService())
+
+	var tests = []struct {
+		name  string
+		input string
+	}{
+		{"Empty token", ""},
+		{"Too short token", base64.StdEncoding.EncodeToString([]byte("short"))},
+		{"Missing secret", base64.StdEncoding.EncodeToString(make([]byte, 16+4))},
+		{"Invalid ip length", base64.StdEncoding.EncodeToString(make([]byte, 16+refreshTokenSecretLength+3))},
+		{"Not base64", "not a refresh token"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := js.GetRefreshTokenPayload(tt.input); err == nil {
+				t.Errorf("got valid refresh token, want error")
+			}
+		})
+	}
+}
+
+func TestJWTServiceRolesAndPermissionsClaims(t *testing.T) {
+	us := uuid.NewUUIDService()
+	js := NewJWTService("MTIzNA==", us)
+
+	var tests = []struct {
+		name            string
+		roles           []string
+		permissions     []string
+		wantRoles       []string
+		wantPermissions []string
+	}{
+		{"Admin", []string{"admin"}, []string{"users:create", "users:read"}, []string{"admin"}, []string{"users:create", "users:read"}},
+		{"User without roles", nil, nil, nil, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issuedAt := time.Now()
+			jti := us.New()
+			accessPayload := &auth.AccessPayload{Jti: jti, Sub: us.New(), Sid: us.New(), IP: "127.0.0.1", Iat: issuedAt.Unix(), Nbf: issuedAt.Unix(), Exp: issuedAt.Add(5 * time.Minute).Unix(), Roles: tt.roles, Permissions: tt.permissions}
+			accessToken, _, err := js.GenerateTokens(&auth.RefreshPayload{Jti: jti}, accessPayload)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			payload, err := js.GetAccessTokenPayload(accessToken)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(payload.Roles, tt.wantRoles) {
+				t.Errorf("got roles %v, want roles %v", payload.Roles, tt.wantRoles)
+			}
+			if !slices.Equal(payload.Permissions, tt.wantPermissions) {
+				t.Errorf("got permissions %v, want permissions %v", payload.Permissions, tt.wantPermissions)
+			}
+		})
+	}
+}
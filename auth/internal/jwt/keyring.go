@@ -0,0 +1,191 @@
+package jwt
+
+import (
+	"encoding/base64"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+const (
+	activeKeyName = "active"
+	pemKeyExt     = ".pem"
+	secretKeyExt  = ".secret"
+)
+
+// KeyRing holds the single active key which signs new tokens and retired keys,
+// which no longer sign, but still verify tokens issued before they were retired.
+// Keys are selected by `kid` and can be changed at runtime, allowing to rotate them without a restart
+type KeyRing struct {
+	mu      sync.RWMutex
+	active  *Key
+	retired map[string]*Key
+}
+
+func NewKeyRing(active *Key, retired ...*Key) *KeyRing {
+	k := &KeyRing{}
+	k.Replace(active, retired...)
+
+	return k
+}
+
+func (k *KeyRing) Active() *Key {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	return k.active
+}
+
+// Returns either active or retired key
+func (k *KeyRing) Get(kid string) (*Key, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	if k.active.Kid == kid {
+		return k.active, true
+	}
+	key, ok := k.retired[kid]
+	return key, ok
+}
+
+// Returns all keys, active one first
+func (k *KeyRing) Keys() []*Key {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	retired := slices.Collect(maps.Values(k.retired))
+	slices.SortFunc(retired, func(a, b *Key) int {
+		return strings.Compare(a.Kid, b.Kid)
+	})
+
+	return append([]*Key{k.active}, retired...)
+}
+
+// Adds key which is only used for verification. Publishing the next key before promoting it
+// gives other services time to pick it up from JWKS
+func (k *KeyRing) Add(key *Key) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if key.Kid != k.active.Kid {
+		k.retired[key.Kid] = key
+	}
+}
+
+// Makes the key active, retiring the previously active one
+func (k *KeyRing) Promote(kid string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.active.Kid == kid {
+		return nil
+	}
+	key, ok := k.retired[kid]
+	if !ok {
+		return fmt.Errorf("error promoting key: unknown kid %s", kid)
+	}
+
+	delete(k.retired, kid)
+	k.retired[k.active.Kid] = k.active
+	k.active = key
+
+	return nil
+}
+
+// Stops accepting tokens signed with the retired key. Should be done no sooner than
+// the lifetime of access tokens after the key was retired
+func (k *KeyRing) Remove(kid string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.active.Kid == kid {
+		return fmt.Errorf("error removing key: key %s is active", kid)
+	}
+	if _, ok := k.retired[kid]; !ok {
+		return fmt.Errorf("error removing key: unknown kid %s", kid)
+	}
+
+	delete(k.retired, kid)
+
+	return nil
+}
+
+// Atomically swaps all keys of the ring
+func (k *KeyRing) Replace(active *Key, retired ...*Key) {
+	retiredMap := make(map[string]*Key, len(retired))
+	for _, key := range retired {
+		if key.Kid != active.Kid {
+			retiredMap[key.Kid] = key
+		}
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.active = active
+	k.retired = retiredMap
+}
+
+// Loads keys from the directory, where:
+// - `*.pem` files contain PEM encoded asymmetric private keys
+// - `*.secret` files contain base64 encoded HMAC secrets
+// - `active.pem` or `active.secret` is the active key, usually a symlink to one of the other files
+//
+// All other keys are retired
+func LoadKeyDir(dir string) (active *Key, retired []*Key, err error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading keys directory: %w", err)
+	}
+
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		if entry.IsDir() || (ext != pemKeyExt && ext != secretKeyExt) {
+			continue
+		}
+
+		key, err := loadKeyFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, nil, fmt.Errorf("error loading key %s: %w", entry.Name(), err)
+		}
+
+		if strings.TrimSuffix(entry.Name(), ext) == activeKeyName {
+			if active != nil {
+				return nil, nil, fmt.Errorf("error loading keys directory: multiple active keys")
+			}
+			active = key
+		} else {
+			retired = append(retired, key)
+		}
+	}
+
+	if active == nil {
+		return nil, nil, fmt.Errorf("error loading keys directory: %s has no %s%s or %s%s key", dir, activeKeyName, pemKeyExt, activeKeyName, secretKeyExt)
+	}
+
+	return active, retired, nil
+}
+
+func loadKeyFile(path string) (*Key, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if filepath.Ext(path) == pemKeyExt {
+		return ParsePrivateKeyPEM(content)
+	}
+
+	secret, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(content)))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't convert secret into bytes: %w", err)
+	}
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("secret is empty")
+	}
+	return NewHMACKey(secret), nil
+}
@@ -0,0 +1,147 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	auth "github.com/medods-technical-assessment"
+	"github.com/medods-technical-assessment/internal/uuid"
+)
+
+func generateAccessToken(t *testing.T, js *JWTService) string {
+	us := uuid.NewUUIDService()
+	issuedAt := time.Now()
+	jti := us.New()
+	accessPayload := &auth.AccessPayload{Jti: jti, IP: "127.0.0.1", Iat: issuedAt.Unix(), Exp: issuedAt.Add(5 * time.Minute).Unix()}
+
+	accessToken, _, err := js.GenerateTokens(&auth.RefreshPayload{Jti: jti}, accessPayload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return accessToken
+}
+
+func TestKeyRingRotation(t *testing.T) {
+	oldKey := NewHMACKey([]byte("old secret"))
+	newKey := NewHMACKey([]byte("new secret"))
+	keys := NewKeyRing(oldKey)
+	js := NewJWTServiceWithKeyRing(keys, uuid.NewUUIDService())
+
+	oldToken := generateAccessToken(t, js)
+
+	// Staged key doesn't sign until promoted
+	keys.Add(newKey)
+	if keys.Active().Kid != oldKey.Kid {
+		t.Errorf("got active key %v, want %v", keys.Active().Kid, oldKey.Kid)
+	}
+
+	if err := keys.Promote(newKey.Kid); err != nil {
+		t.Fatal(err)
+	}
+	newToken := generateAccessToken(t, js)
+
+	var tests = []struct {
+		name      string
+		input     string
+		wantValid bool
+	}{
+		{"Token signed with retired key", oldToken, true},
+		{"Token signed with active key", newToken, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := js.VerifyAccessToken(tt.input)
+			isValid := err == nil
+
+			if isValid != tt.wantValid {
+				t.Errorf("got valid %v, want valid %v (%v)", isValid, tt.wantValid, err)
+			}
+		})
+	}
+
+	if err := keys.Remove(newKey.Kid); err == nil {
+		t.Errorf("got active key removed, want error")
+	}
+	if err := keys.Remove(oldKey.Kid); err != nil {
+		t.Fatal(err)
+	}
+	if err := js.VerifyAccessToken(oldToken); err == nil {
+		t.Errorf("got token signed with removed key verified, want error")
+	}
+	if err := js.VerifyAccessToken(newToken); err != nil {
+		t.Errorf("got error verifying token signed with active key: %v", err)
+	}
+}
+
+func TestLoadKeyDir(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ecPEM := encodePKCS8(t, ecKey)
+
+	type file struct {
+		name    string
+		content []byte
+	}
+	var tests = []struct {
+		name        string
+		input       []file
+		wantRetired int
+		wantAlg     string
+		wantValid   bool
+	}{
+		{"Active secret", []file{
+			{"active.secret", []byte("MTIzNA==\n")},
+		}, 0, "HS512", true},
+		{"Active asymmetric key and retired secret", []file{
+			{"active.pem", ecPEM},
+			{"2024-12.secret", []byte("MTIzNA==")},
+			{"README.md", []byte("not a key")},
+		}, 1, "ES256", true},
+		{"Active key duplicated by another file", []file{
+			{"active.pem", ecPEM},
+			{"2025-01.pem", ecPEM},
+		}, 0, "ES256", true},
+		{"No active key", []file{
+			{"2024-12.secret", []byte("MTIzNA==")},
+		}, 0, "", false},
+		{"Invalid key", []file{
+			{"active.pem", []byte("not a key")},
+		}, 0, "", false},
+		{"Empty secret", []file{
+			{"active.secret", []byte("")},
+		}, 0, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, f := range tt.input {
+				if err := os.WriteFile(filepath.Join(dir, f.name), f.content, 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			active, retired, err := LoadKeyDir(dir)
+			isValid := err == nil
+			if isValid != tt.wantValid {
+				t.Errorf("got valid %v, want valid %v (%v)", isValid, tt.wantValid, err)
+				return
+			}
+			if !isValid {
+				return
+			}
+
+			keys := NewKeyRing(active, retired...)
+			if len(keys.Keys())-1 != tt.wantRetired {
+				t.Errorf("got %v retired keys, want %v", len(keys.Keys())-1, tt.wantRetired)
+			}
+			if active.Method.Alg() != tt.wantAlg {
+				t.Errorf("got active alg %v, want %v", active.Method.Alg(), tt.wantAlg)
+			}
+		})
+	}
+}
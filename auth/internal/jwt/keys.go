@@ -0,0 +1,177 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+
+	auth "github.com/medods-technical-assessment"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const minRSAKeyBits = 2048
+
+// Key couples a key used to sign tokens with the one used to verify them.
+// For HMAC both are the same secret, for asymmetric algorithms the latter is public
+type Key struct {
+	// Key ID, RFC 7638 thumbprint of the key
+	Kid       string
+	Method    jwt.SigningMethod
+	signKey   any
+	verifyKey any
+	// Public part of the key, nil for symmetric keys which must never be published
+	jwk *auth.JWK
+}
+
+func NewHMACKey(secret []byte) *Key {
+	// ref: https://datatracker.ietf.org/doc/html/rfc7638#section-3.2
+	kid := thumbprint(map[string]string{
+		"k":   base64.RawURLEncoding.EncodeToString(secret),
+		"kty": "oct",
+	})
+
+	return &Key{
+		Kid:       kid,
+		Method:    jwt.SigningMethodHS512,
+		signKey:   secret,
+		verifyKey: secret,
+	}
+}
+
+// Supports PKCS #8, PKCS #1 (RSA) and SEC 1 (EC) encoded private keys:
+// - RSA keys are used with RS256
+// - ECDSA keys with ES256, ES384 or ES512, depending on the curve
+// - Ed25519 keys with EdDSA
+func ParsePrivateKeyPEM(pemBytes []byte) (*Key, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, fmt.Errorf("error parsing private key: no PEM block found")
+	}
+
+	var privateKey any
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		privateKey, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		privateKey, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		privateKey, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("error parsing private key: unsupported PEM block type %q", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing private key: %w", err)
+	}
+
+	return newAsymmetricKey(privateKey)
+}
+
+// Loads private key either from PEM string or, if the string is empty, from PEM file
+func LoadPrivateKey(pemStr, pemFile string) (*Key, error) {
+	if pemStr != "" {
+		// Allows passing multiline PEM through single line environment variables
+		return ParsePrivateKeyPEM([]byte(strings.ReplaceAll(pemStr, `\n`, "\n")))
+	}
+
+	pemBytes, err := os.ReadFile(pemFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading private key file: %w", err)
+	}
+	return ParsePrivateKeyPEM(pemBytes)
+}
+
+func newAsymmetricKey(privateKey any) (*Key, error) {
+	key := &Key{signKey: privateKey}
+
+	switch privateKey := privateKey.(type) {
+	case *rsa.PrivateKey:
+		if privateKey.N.BitLen() < minRSAKeyBits {
+			return nil, fmt.Errorf("error parsing private key: RSA key must be at least %d bits", minRSAKeyBits)
+		}
+		key.Method = jwt.SigningMethodRS256
+		key.verifyKey = &privateKey.PublicKey
+		key.jwk = &auth.JWK{
+			Kty: "RSA",
+			N:   base64.RawURLEncoding.EncodeToString(privateKey.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(privateKey.E)).Bytes()),
+		}
+	case *ecdsa.PrivateKey:
+		switch privateKey.Curve {
+		case elliptic.P256():
+			key.Method = jwt.SigningMethodES256
+		case elliptic.P384():
+			key.Method = jwt.SigningMethodES384
+		case elliptic.P521():
+			key.Method = jwt.SigningMethodES512
+		default:
+			return nil, fmt.Errorf("error parsing private key: unsupported elliptic curve")
+		}
+		params := privateKey.Curve.Params()
+		// Coordinates are padded to the size of the curve
+		// ref: https://datatracker.ietf.org/doc/html/rfc7518#section-6.2.1.2
+		size := (params.BitSize + 7) / 8
+		key.verifyKey = &privateKey.PublicKey
+		key.jwk = &auth.JWK{
+			Kty: "EC",
+			Crv: params.Name,
+			X:   base64.RawURLEncoding.EncodeToString(privateKey.X.FillBytes(make([]byte, size))),
+			Y:   base64.RawURLEncoding.EncodeToString(privateKey.Y.FillBytes(make([]byte, size))),
+		}
+	case ed25519.PrivateKey:
+		publicKey := privateKey.Public().(ed25519.PublicKey)
+		key.Method = jwt.SigningMethodEdDSA
+		key.verifyKey = publicKey
+		key.jwk = &auth.JWK{
+			Kty: "OKP",
+			Crv: "Ed25519",
+			X:   base64.RawURLEncoding.EncodeToString(publicKey),
+		}
+	default:
+		return nil, fmt.Errorf("error parsing private key: unsupported key type %T", privateKey)
+	}
+
+	key.Kid = thumbprint(thumbprintMembers(key.jwk))
+	key.jwk.Kid = key.Kid
+	key.jwk.Use = "sig"
+	key.jwk.Alg = key.Method.Alg()
+
+	return key, nil
+}
+
+// Public JWK of the key, or nil if the key is symmetric
+func (k *Key) PublicJWK() *auth.JWK {
+	return k.jwk
+}
+
+// Required members of the public key, as they are the only ones participating in the thumbprint
+// ref: https://datatracker.ietf.org/doc/html/rfc7638#section-3.2
+func thumbprintMembers(jwk *auth.JWK) map[string]string {
+	switch jwk.Kty {
+	case "RSA":
+		return map[string]string{"e": jwk.E, "kty": jwk.Kty, "n": jwk.N}
+	case "EC":
+		return map[string]string{"crv": jwk.Crv, "kty": jwk.Kty, "x": jwk.X, "y": jwk.Y}
+	default:
+		return map[string]string{"crv": jwk.Crv, "kty": jwk.Kty, "x": jwk.X}
+	}
+}
+
+// ref: https://datatracker.ietf.org/doc/html/rfc7638#section-3
+func thumbprint(members map[string]string) string {
+	// encoding/json sorts map keys and doesn't add whitespace, producing the required canonical form
+	canonical, _ := json.Marshal(members)
+	sum := sha256.Sum256(canonical)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,155 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"strings"
+	"testing"
+	"time"
+
+	auth "github.com/medods-technical-assessment"
+	"github.com/medods-technical-assessment/internal/uuid"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func encodePKCS8(t *testing.T, privateKey any) []byte {
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func TestParsePrivateKeyPEM(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	weakRSAKey, _ := rsa.GenerateKey(rand.Reader, 1024)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ecDer, _ := x509.MarshalECPrivateKey(ecKey)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	var tests = []struct {
+		name      string
+		input     []byte
+		wantAlg   string
+		wantValid bool
+	}{
+		{"Valid PKCS #8 RSA key", encodePKCS8(t, rsaKey), "RS256", true},
+		{"Valid PKCS #1 RSA key", pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}), "RS256", true},
+		{"Valid PKCS #8 EC key", encodePKCS8(t, ecKey), "ES256", true},
+		{"Valid SEC 1 EC key", pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: ecDer}), "ES256", true},
+		{"Valid PKCS #8 Ed25519 key", encodePKCS8(t, edKey), "EdDSA", true},
+		{"Too short RSA key", encodePKCS8(t, weakRSAKey), "", false},
+		{"Public key instead of private", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: []byte("123")}), "", false},
+		{"Not a PEM", []byte("hello"), "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := ParsePrivateKeyPEM(tt.input)
+			isValid := err == nil
+
+			if isValid != tt.wantValid {
+				t.Errorf("got valid %v, want valid %v (%v)", isValid, tt.wantValid, err)
+				return
+			}
+			if isValid && key.Method.Alg() != tt.wantAlg {
+				t.Errorf("got alg %v, want alg %v", key.Method.Alg(), tt.wantAlg)
+			}
+		})
+	}
+}
+
+func TestJWTServiceAsymmetricKeys(t *testing.T) {
+	us := uuid.NewUUIDService()
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	var tests = []struct {
+		name    string
+		input   []byte
+		wantKty string
+	}{
+		{"RS256", encodePKCS8(t, rsaKey), "RSA"},
+		{"ES384", encodePKCS8(t, ecKey), "EC"},
+		{"EdDSA", encodePKCS8(t, edKey), "OKP"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := ParsePrivateKeyPEM(tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			js := NewJWTServiceWithKey(key, us)
+
+			issuedAt := time.Now()
+			jti := us.New()
+			accessPayload := &auth.AccessPayload{Jti: jti, IP: "127.0.0.1", Iat: issuedAt.Unix(), Exp: issuedAt.Add(5 * time.Minute).Unix()}
+			accessToken, _, err := js.GenerateTokens(&auth.RefreshPayload{Jti: jti}, accessPayload)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			headerBytes, _ := base64.RawURLEncoding.DecodeString(strings.Split(accessToken, ".")[0])
+			header := map[string]string{}
+			json.Unmarshal(headerBytes, &header)
+			if header["alg"] != tt.name || header["kid"] != key.Kid {
+				t.Errorf("got header %v, want alg %v and kid %v", header, tt.name, key.Kid)
+			}
+
+			if err = js.VerifyAccessToken(accessToken); err != nil {
+				t.Errorf("got error verifying access token: %v", err)
+			}
+
+			jwks := js.GetJWKS()
+			if len(jwks.Keys) != 1 || jwks.Keys[0].Kid != key.Kid || jwks.Keys[0].Kty != tt.wantKty || jwks.Keys[0].Alg != tt.name {
+				t.Errorf("got jwks %+v, want single %v key %v", jwks.Keys, tt.wantKty, key.Kid)
+			}
+		})
+	}
+}
+
+func TestJWTServiceRejectsAlgorithmConfusion(t *testing.T) {
+	us := uuid.NewUUIDService()
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	key, err := ParsePrivateKeyPEM(encodePKCS8(t, rsaKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	js := NewJWTServiceWithKey(key, us)
+
+	// Public key is known to everyone, so it must not be accepted as an HMAC secret
+	publicKeyDer, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	publicKeyPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKeyDer})
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS512, jwt.MapClaims{
+		"jti": us.New(),
+		"ip":  "",
+		"iat": time.Now().Unix(),
+		"exp": time.Now().Add(5 * time.Minute).Unix(),
+	})
+	token.Header["kid"] = key.Kid
+	token.Header["typ"] = accessTokenType
+	forgedToken, _ := token.SignedString(publicKeyPEM)
+
+	if err = js.VerifyAccessToken(forgedToken); err == nil {
+		t.Errorf("got forged token verified, want error")
+	}
+}
+
+func TestJWTServiceHMACKeyIsNotPublished(t *testing.T) {
+	js := NewJWTService("MTIzNA==", uuid.NewUUIDService())
+
+	if jwks := js.GetJWKS(); len(jwks.Keys) != 0 {
+		t.Errorf("got %v published keys, want none", len(jwks.Keys))
+	}
+}
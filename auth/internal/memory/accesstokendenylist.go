@@ -0,0 +1,55 @@
+package memory
+
+import (
+	"sync"
+	"time"
+
+	auth "github.com/medods-technical-assessment"
+)
+
+// How often expired entries are purged
+const denylistPurgeInterval = time.Minute
+
+// AccessTokenDenylist represents an in-memory implementation of auth.AccessTokenDenylist.
+// It is only suitable for a single instance of the service, as instances don't share it
+type AccessTokenDenylist struct {
+	mu         sync.RWMutex
+	expiresAt  map[auth.UUID]time.Time
+	lastPurged time.Time
+}
+
+func NewAccessTokenDenylist() *AccessTokenDenylist {
+	return &AccessTokenDenylist{
+		expiresAt:  make(map[auth.UUID]time.Time),
+		lastPurged: time.Now(),
+	}
+}
+
+func (d *AccessTokenDenylist) Add(jti auth.UUID, expiresAt time.Time) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(d.lastPurged) >= denylistPurgeInterval {
+		for jti, expiresAt := range d.expiresAt {
+			if expiresAt.Before(now) {
+				delete(d.expiresAt, jti)
+			}
+		}
+		d.lastPurged = now
+	}
+
+	if !expiresAt.Before(now) {
+		d.expiresAt[jti] = expiresAt
+	}
+
+	return nil
+}
+
+func (d *AccessTokenDenylist) Contains(jti auth.UUID) (bool, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	expiresAt, ok := d.expiresAt[jti]
+	return ok && !expiresAt.Before(time.Now()), nil
+}
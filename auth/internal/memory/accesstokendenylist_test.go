@@ -0,0 +1,53 @@
+package memory
+
+import (
+	"testing"
+	"time"
+
+	"github.com/medods-technical-assessment/internal/uuid"
+)
+
+func TestAccessTokenDenylist(t *testing.T) {
+	us := uuid.NewUUIDService()
+	d := NewAccessTokenDenylist()
+
+	revoked := us.New()
+	expired := us.New()
+	d.Add(revoked, time.Now().Add(5*time.Minute))
+	d.Add(expired, time.Now().Add(-time.Second))
+
+	var tests = []struct {
+		name         string
+		input        string
+		wantContains bool
+	}{
+		{"Revoked token", revoked.String(), true},
+		{"Revoked token which has already expired", expired.String(), false},
+		{"Not revoked token", us.New().String(), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			contains, err := d.Contains(us.MustParse(tt.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if contains != tt.wantContains {
+				t.Errorf("got contains %v, want contains %v", contains, tt.wantContains)
+			}
+		})
+	}
+}
+
+func TestAccessTokenDenylistPurgesExpiredEntries(t *testing.T) {
+	us := uuid.NewUUIDService()
+	d := NewAccessTokenDenylist()
+
+	d.expiresAt[us.New()] = time.Now().Add(-time.Second)
+	d.lastPurged = time.Now().Add(-denylistPurgeInterval)
+	d.Add(us.New(), time.Now().Add(time.Minute))
+
+	if len(d.expiresAt) != 1 {
+		t.Errorf("got %v entries, want %v", len(d.expiresAt), 1)
+	}
+}
@@ -0,0 +1,81 @@
+package memory
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"strings"
+
+	auth "github.com/medods-technical-assessment"
+)
+
+type registeredClient struct {
+	client       *auth.Client
+	hashedSecret [sha256.Size]byte
+}
+
+// ClientService represents an in-memory implementation of auth.ClientService,
+// with service clients registered through configuration
+type ClientService struct {
+	clients map[string]*registeredClient
+}
+
+// Parses clients from `id:secret:grant1,grant2` entries separated by `;`,
+// e.g. `reporting:s3cret:introspect;billing:an0ther:introspect,revoke`
+func NewClientService(clientsStr string) *ClientService {
+	clients := make(map[string]*registeredClient)
+
+	for _, entry := range strings.Split(clientsStr, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		fields := strings.Split(entry, ":")
+		if len(fields) != 3 || fields[0] == "" || fields[1] == "" {
+			log.Panic(fmt.Errorf("error creating client service: client entry must be in the form of id:secret:grants"))
+		}
+		id, secret, grants := fields[0], fields[1], fields[2]
+
+		if _, ok := clients[id]; ok {
+			log.Panic(fmt.Errorf("error creating client service: client %s is registered twice", id))
+		}
+
+		clients[id] = &registeredClient{
+			client: &auth.Client{
+				ID:     id,
+				Grants: strings.FieldsFunc(grants, func(r rune) bool { return r == ',' }),
+			},
+			hashedSecret: sha256.Sum256([]byte(secret)),
+		}
+	}
+
+	return &ClientService{
+		clients: clients,
+	}
+}
+
+func (s *ClientService) AuthenticateClient(clientID, clientSecret string) (*auth.Client, error) {
+	hashedSecret := sha256.Sum256([]byte(clientSecret))
+
+	registered, ok := s.clients[clientID]
+	// Comparing hashes of equal length keeps the comparison constant-time
+	if !ok || subtle.ConstantTimeCompare(hashedSecret[:], registered.hashedSecret[:]) != 1 {
+		return nil, fmt.Errorf("invalid client credentials")
+	}
+
+	return registered.client, nil
+}
+
+// Common name of the certificate is the client id, so that only registered clients are let in,
+// even if the CA has issued certificates to other services
+func (s *ClientService) AuthenticateClientCertificate(cert *x509.Certificate) (*auth.Client, error) {
+	registered, ok := s.clients[cert.Subject.CommonName]
+	if !ok {
+		return nil, fmt.Errorf("invalid client certificate: client %q is not registered", cert.Subject.CommonName)
+	}
+
+	return registered.client, nil
+}
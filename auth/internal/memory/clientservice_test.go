@@ -0,0 +1,94 @@
+package memory
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"slices"
+	"testing"
+)
+
+func TestClientServiceAuthenticateClient(t *testing.T) {
+	cs := NewClientService("reporting:s3cret:introspect; billing:an0ther+/=:introspect,revoke;")
+
+	var tests = []struct {
+		name       string
+		id         string
+		secret     string
+		wantValid  bool
+		wantGrants []string
+	}{
+		{"Valid credentials", "reporting", "s3cret", true, []string{"introspect"}},
+		{"Valid credentials with multiple grants", "billing", "an0ther+/=", true, []string{"introspect", "revoke"}},
+		{"Wrong secret", "reporting", "an0ther+/=", false, nil},
+		{"Empty secret", "reporting", "", false, nil},
+		{"Unknown client", "unknown", "s3cret", false, nil},
+		{"Empty client", "", "", false, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := cs.AuthenticateClient(tt.id, tt.secret)
+			isValid := err == nil
+
+			if isValid != tt.wantValid {
+				t.Errorf("got valid %v, want valid %v", isValid, tt.wantValid)
+				return
+			}
+			if isValid && !slices.Equal(client.Grants, tt.wantGrants) {
+				t.Errorf("got grants %v, want grants %v", client.Grants, tt.wantGrants)
+			}
+		})
+	}
+}
+
+func TestClientServiceAuthenticateClientCertificate(t *testing.T) {
+	cs := NewClientService("support-portal:s3cret:login_by_uuid")
+
+	var tests = []struct {
+		name       string
+		commonName string
+		wantValid  bool
+	}{
+		{"Registered client", "support-portal", true},
+		{"Unregistered client", "billing", false},
+		{"Empty common name", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cert := &x509.Certificate{Subject: pkix.Name{CommonName: tt.commonName}}
+			client, err := cs.AuthenticateClientCertificate(cert)
+			isValid := err == nil
+
+			if isValid != tt.wantValid {
+				t.Errorf("got valid %v, want valid %v", isValid, tt.wantValid)
+				return
+			}
+			if isValid && client.ID != tt.commonName {
+				t.Errorf("got client %v, want client %v", client.ID, tt.commonName)
+			}
+		})
+	}
+}
+
+func TestNewClientServicePanicsOnInvalidConfig(t *testing.T) {
+	var tests = []struct {
+		name  string
+		input string
+	}{
+		{"Missing grants", "reporting:s3cret"},
+		{"Missing secret", "reporting::introspect"},
+		{"Duplicate client", "reporting:s3cret:introspect;reporting:an0ther:introspect"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("got no panic, want panic")
+				}
+			}()
+			NewClientService(tt.input)
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package memory
+
+import (
+	"sync"
+	"time"
+
+	auth "github.com/medods-technical-assessment"
+)
+
+// How often counters nobody has hit for a while are purged
+const rateLimitPurgeInterval = time.Minute
+
+type rateLimitEntry struct {
+	counter auth.RateLimitCounter
+	// Once the previous window no longer overlaps the sliding one, the counter is of no use
+	expiresAt time.Time
+}
+
+// RateLimitStore represents an in-memory implementation of auth.RateLimitStore.
+// It is only suitable for a single instance of the service, as instances don't share it
+type RateLimitStore struct {
+	mu         sync.Mutex
+	entries    map[string]*rateLimitEntry
+	lastPurged time.Time
+}
+
+func NewRateLimitStore() *RateLimitStore {
+	return &RateLimitStore{
+		entries:    make(map[string]*rateLimitEntry),
+		lastPurged: time.Now(),
+	}
+}
+
+func (s *RateLimitStore) Hit(key string, window time.Duration, at time.Time) (*auth.RateLimitCounter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.lastPurged) >= rateLimitPurgeInterval {
+		for key, entry := range s.entries {
+			if entry.expiresAt.Before(now) {
+				delete(s.entries, key)
+			}
+		}
+		s.lastPurged = now
+	}
+
+	windowStart := at.Truncate(window)
+	entry, ok := s.entries[key]
+	if !ok {
+		entry = &rateLimitEntry{}
+		s.entries[key] = entry
+	}
+
+	switch {
+	case entry.counter.WindowStart.Equal(windowStart):
+		entry.counter.Current++
+	case entry.counter.WindowStart.Equal(windowStart.Add(-window)):
+		entry.counter.Previous, entry.counter.Current = entry.counter.Current, 1
+	default:
+		entry.counter.Previous, entry.counter.Current = 0, 1
+	}
+	entry.counter.WindowStart = windowStart
+	entry.counter.PreviousHitAt = entry.counter.LastHitAt
+	entry.counter.LastHitAt = at
+	entry.expiresAt = windowStart.Add(2 * window)
+
+	counter := entry.counter
+	return &counter, nil
+}
+
+func (s *RateLimitStore) Get(key string) (*auth.RateLimitCounter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[key]
+	if !ok || entry.expiresAt.Before(time.Now()) {
+		return nil, nil
+	}
+
+	counter := entry.counter
+	return &counter, nil
+}
+
+func (s *RateLimitStore) Undo(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if entry, ok := s.entries[key]; ok && entry.counter.Current > 0 {
+		entry.counter.Current--
+	}
+	return nil
+}
+
+func (s *RateLimitStore) Reset(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, key)
+	return nil
+}
@@ -0,0 +1,95 @@
+package memory
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimitStoreSlidingWindow(t *testing.T) {
+	window := time.Minute
+	start := time.Now().Truncate(window)
+
+	var tests = []struct {
+		name      string
+		hits      []time.Duration
+		at        time.Duration
+		wantCount float64
+	}{
+		{"No hits", nil, 0, 0},
+		{"Hits of current window", []time.Duration{0, time.Second, 2 * time.Second}, 30 * time.Second, 3},
+		{"Hits of previous window are weighted", []time.Duration{0, time.Second}, 90 * time.Second, 1},
+		{"Hits of both windows", []time.Duration{0, time.Second, 65 * time.Second}, 75 * time.Second, 1 + 2*0.75},
+		{"Hits of older windows are ignored", []time.Duration{0, time.Second}, 150 * time.Second, 0},
+		{"Previous window is shifted by a hit", []time.Duration{0, 2 * window, 2*window + time.Second}, 2*window + 30*time.Second, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewRateLimitStore()
+			for _, hit := range tt.hits {
+				if _, err := s.Hit("key", window, start.Add(hit)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			counter, err := s.Get("key")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var count float64
+			if counter != nil {
+				count = counter.Count(window, start.Add(tt.at))
+			}
+
+			if count != tt.wantCount {
+				t.Errorf("got count %v, want count %v", count, tt.wantCount)
+			}
+		})
+	}
+}
+
+func TestRateLimitStoreReset(t *testing.T) {
+	s := NewRateLimitStore()
+	s.Hit("key", time.Minute, time.Now())
+	s.Hit("other key", time.Minute, time.Now())
+
+	s.Reset("key")
+
+	if counter, _ := s.Get("key"); counter != nil {
+		t.Errorf("got counter %v, want no counter", counter)
+	}
+	if counter, _ := s.Get("other key"); counter == nil {
+		t.Errorf("got no counter of other key, want counter")
+	}
+}
+
+func TestRateLimitStoreUndo(t *testing.T) {
+	s := NewRateLimitStore()
+	first, second := time.Now(), time.Now().Add(time.Second)
+	s.Hit("key", time.Hour, first)
+	s.Hit("key", time.Hour, second)
+
+	s.Undo("key")
+	s.Undo("key")
+	s.Undo("key")
+
+	counter, _ := s.Get("key")
+	if counter == nil || counter.Current != 0 {
+		t.Fatalf("got counter %v, want no hits left", counter)
+	}
+	if !counter.LastHitAt.Equal(second) || !counter.PreviousHitAt.Equal(first) {
+		t.Errorf("got hits at %v and %v, want hits at %v and %v", counter.PreviousHitAt, counter.LastHitAt, first, second)
+	}
+}
+
+func TestRateLimitStorePurgesExpiredEntries(t *testing.T) {
+	s := NewRateLimitStore()
+
+	s.Hit("expired", time.Minute, time.Now().Add(-time.Hour))
+	s.lastPurged = time.Now().Add(-rateLimitPurgeInterval)
+	s.Hit("key", time.Minute, time.Now())
+
+	if len(s.entries) != 1 {
+		t.Errorf("got %v entries, want %v", len(s.entries), 1)
+	}
+}
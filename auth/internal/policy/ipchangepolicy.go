@@ -0,0 +1,140 @@
+package policy
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+
+	auth "github.com/medods-technical-assessment"
+)
+
+// Rule of users without a rule for any of their roles
+const AnyRole = "*"
+
+// Addresses within the same network are likely the same client, e.g. a home router renewing its lease
+const (
+	sameNetworkPrefixIPv4 = 24
+	sameNetworkPrefixIPv6 = 64
+)
+
+var ipChangeActions = []auth.IPChangeAction{
+	auth.IPChangeActionIgnore,
+	auth.IPChangeActionNotify,
+	auth.IPChangeActionReauthenticate,
+	auth.IPChangeActionDeny,
+}
+
+// IPChangeRule decides what happens when a session of a user with the role moves to another address
+type IPChangeRule struct {
+	Action auth.IPChangeAction
+	// Whether changes within the same /24 or /64 network are ignored
+	IgnoreSameNetwork bool
+}
+
+// Notifies users of any change, as the service always has
+var DefaultIPChangeRules = map[string]IPChangeRule{
+	AnyRole: {Action: auth.IPChangeActionNotify},
+}
+
+// IPChangePolicy represents an implementation of auth.IPChangePolicy with rules per role.
+// Users with several roles are held to the strictest of their rules
+type IPChangePolicy struct {
+	rules map[string]IPChangeRule
+}
+
+func NewIPChangePolicy(rules map[string]IPChangeRule) *IPChangePolicy {
+	return &IPChangePolicy{
+		rules: rules,
+	}
+}
+
+// Parses `role:action` entries separated by `;`, where the action may be followed by `,same_network`
+// to ignore changes within the same network, and role `*` applies to users without a rule for their roles,
+// e.g. `*:notify,same_network;admin:deny`
+func ParseIPChangeRules(rulesStr string) (map[string]IPChangeRule, error) {
+	rules := make(map[string]IPChangeRule)
+
+	for _, entry := range strings.Split(rulesStr, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		role, ruleStr, ok := strings.Cut(entry, ":")
+		if !ok || role == "" {
+			return nil, fmt.Errorf("ip change rule must be in the form of role:action")
+		}
+		if _, ok := rules[role]; ok {
+			return nil, fmt.Errorf("ip change rule for role %s is set twice", role)
+		}
+
+		options := strings.Split(ruleStr, ",")
+		rule := IPChangeRule{Action: auth.IPChangeAction(strings.TrimSpace(options[0]))}
+		if !slices.Contains(ipChangeActions, rule.Action) {
+			return nil, fmt.Errorf("ip change action of role %s must be one of ignore, notify, reauthenticate or deny", role)
+		}
+		for _, option := range options[1:] {
+			if strings.TrimSpace(option) != "same_network" {
+				return nil, fmt.Errorf("unknown ip change option %s of role %s", option, role)
+			}
+			rule.IgnoreSameNetwork = true
+		}
+
+		rules[role] = rule
+	}
+
+	return rules, nil
+}
+
+// Addresses which can't be told, e.g. of sessions started before ip was recorded, are not considered a change
+func (p *IPChangePolicy) Decide(roles []string, previous netip.Addr, current netip.Addr) auth.IPChangeAction {
+	previous, current = previous.Unmap(), current.Unmap()
+	if !previous.IsValid() || !current.IsValid() || previous == current {
+		return auth.IPChangeActionIgnore
+	}
+
+	rules := make([]IPChangeRule, 0)
+	for _, role := range roles {
+		if rule, ok := p.rules[role]; ok {
+			rules = append(rules, rule)
+		}
+	}
+	if len(rules) == 0 {
+		rule, ok := p.rules[AnyRole]
+		if !ok {
+			return auth.IPChangeActionIgnore
+		}
+		rules = append(rules, rule)
+	}
+
+	action := auth.IPChangeActionIgnore
+	for _, rule := range rules {
+		ruleAction := rule.Action
+		if rule.IgnoreSameNetwork && isSameNetwork(previous, current) {
+			ruleAction = auth.IPChangeActionIgnore
+		}
+		if slices.Index(ipChangeActions, ruleAction) > slices.Index(ipChangeActions, action) {
+			action = ruleAction
+		}
+	}
+
+	return action
+}
+
+func isSameNetwork(a netip.Addr, b netip.Addr) bool {
+	if a.Is4() != b.Is4() {
+		return false
+	}
+
+	bits := sameNetworkPrefixIPv6
+	if a.Is4() {
+		bits = sameNetworkPrefixIPv4
+	}
+	prefix, err := a.Prefix(bits)
+	if err != nil {
+		return false
+	}
+
+	return prefix.Contains(b)
+}
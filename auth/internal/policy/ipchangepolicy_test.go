@@ -0,0 +1,90 @@
+package policy
+
+import (
+	"net/netip"
+	"testing"
+
+	auth "github.com/medods-technical-assessment"
+)
+
+func TestIPChangePolicyDecide(t *testing.T) {
+	rules, err := ParseIPChangeRules("*:notify,same_network; admin:deny; support:reauthenticate,same_network")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := NewIPChangePolicy(rules)
+
+	var tests = []struct {
+		name       string
+		roles      []string
+		previous   string
+		current    string
+		wantAction auth.IPChangeAction
+	}{
+		{"Same address", []string{auth.RoleAdmin}, "203.0.113.7", "203.0.113.7", auth.IPChangeActionIgnore},
+		{"Same address, IPv4-mapped", []string{auth.RoleAdmin}, "203.0.113.7", "::ffff:203.0.113.7", auth.IPChangeActionIgnore},
+		{"Unknown previous address", []string{auth.RoleAdmin}, "", "203.0.113.7", auth.IPChangeActionIgnore},
+		{"User within same IPv4 network", nil, "203.0.113.7", "203.0.113.200", auth.IPChangeActionIgnore},
+		{"User within same IPv6 network", nil, "2001:db8:0:1::7", "2001:db8:0:1:ffff::1", auth.IPChangeActionIgnore},
+		{"User to another network", nil, "203.0.113.7", "198.51.100.7", auth.IPChangeActionNotify},
+		{"User to another IPv6 network", nil, "2001:db8:0:1::7", "2001:db8:0:2::7", auth.IPChangeActionNotify},
+		{"User from IPv4 to IPv6", nil, "203.0.113.7", "2001:db8::7", auth.IPChangeActionNotify},
+		{"User with role without rule", []string{"editor"}, "203.0.113.7", "198.51.100.7", auth.IPChangeActionNotify},
+		{"Admin within same network", []string{auth.RoleAdmin}, "203.0.113.7", "203.0.113.200", auth.IPChangeActionDeny},
+		{"Support within same network", []string{"support"}, "203.0.113.7", "203.0.113.200", auth.IPChangeActionIgnore},
+		{"Support to another network", []string{"support"}, "203.0.113.7", "198.51.100.7", auth.IPChangeActionReauthenticate},
+		{"Strictest of several roles", []string{"support", auth.RoleAdmin}, "203.0.113.7", "203.0.113.200", auth.IPChangeActionDeny},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var previous, current netip.Addr
+			if tt.previous != "" {
+				previous = netip.MustParseAddr(tt.previous)
+			}
+			if tt.current != "" {
+				current = netip.MustParseAddr(tt.current)
+			}
+
+			if action := p.Decide(tt.roles, previous, current); action != tt.wantAction {
+				t.Errorf("got action %v, want action %v", action, tt.wantAction)
+			}
+		})
+	}
+}
+
+func TestIPChangePolicyWithoutDefaultRule(t *testing.T) {
+	p := NewIPChangePolicy(map[string]IPChangeRule{auth.RoleAdmin: {Action: auth.IPChangeActionDeny}})
+
+	previous, current := netip.MustParseAddr("203.0.113.7"), netip.MustParseAddr("198.51.100.7")
+	if action := p.Decide(nil, previous, current); action != auth.IPChangeActionIgnore {
+		t.Errorf("got action %v, want action %v", action, auth.IPChangeActionIgnore)
+	}
+}
+
+func TestParseIPChangeRules(t *testing.T) {
+	var tests = []struct {
+		name      string
+		input     string
+		wantValid bool
+	}{
+		{"Empty", "", true},
+		{"Valid rules", "*:notify,same_network;admin:deny;", true},
+		{"Missing action", "admin", false},
+		{"Missing role", ":deny", false},
+		{"Unknown action", "admin:block", false},
+		{"Unknown option", "admin:deny,same_city", false},
+		{"Duplicate role", "admin:deny;admin:notify", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseIPChangeRules(tt.input)
+			isValid := err == nil
+
+			if isValid != tt.wantValid {
+				t.Errorf("got valid %v, want valid %v (%v)", isValid, tt.wantValid, err)
+			}
+		})
+	}
+}
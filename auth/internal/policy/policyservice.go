@@ -0,0 +1,53 @@
+package policy
+
+import (
+	"fmt"
+
+	auth "github.com/medods-technical-assessment"
+	"github.com/medods-technical-assessment/internal/common"
+)
+
+// Rule decides who may perform an action
+type Rule struct {
+	// Whether the owner of the resource may perform the action on it
+	AllowOwner bool
+	// Permission allowing to perform the action on any resource, regardless of its owner
+	Permission string
+}
+
+// PolicyService represents an implementation of auth.PolicyService,
+// which allows actions either to owners of resources or by permissions
+type PolicyService struct {
+	rules map[auth.Action]Rule
+}
+
+// Rules of the actions on user records
+var DefaultRules = map[auth.Action]Rule{
+	auth.ActionUsersRead:   {AllowOwner: true, Permission: auth.PermissionUsersRead},
+	auth.ActionUsersCreate: {AllowOwner: false, Permission: auth.PermissionUsersCreate},
+	auth.ActionUsersUpdate: {AllowOwner: true, Permission: auth.PermissionUsersUpdate},
+	auth.ActionUsersDelete: {AllowOwner: true, Permission: auth.PermissionUsersDelete},
+}
+
+func NewPolicyService(rules map[auth.Action]Rule) *PolicyService {
+	return &PolicyService{
+		rules: rules,
+	}
+}
+
+// Denies actions without a rule, so that forgetting to add one doesn't open access to everyone
+func (p *PolicyService) Authorize(principal *auth.Principal, action auth.Action, resource *auth.Resource) error {
+	rule, ok := p.rules[action]
+	if !ok {
+		return fmt.Errorf("%w: no rule for action %s", common.ErrAccessDenied, action)
+	}
+
+	if rule.Permission != "" && principal.HasPermission(rule.Permission) {
+		return nil
+	}
+	if rule.AllowOwner && resource != nil && resource.OwnerUUID == principal.UserUUID {
+		return nil
+	}
+
+	return fmt.Errorf("%w: missing permission %s to %s", common.ErrAccessDenied, rule.Permission, action)
+}
@@ -0,0 +1,55 @@
+package policy
+
+import (
+	"errors"
+	"testing"
+
+	auth "github.com/medods-technical-assessment"
+	"github.com/medods-technical-assessment/internal/common"
+	"github.com/medods-technical-assessment/internal/uuid"
+)
+
+func TestPolicyServiceAuthorize(t *testing.T) {
+	us := uuid.NewUUIDService()
+	ps := NewPolicyService(DefaultRules)
+
+	user := &auth.Principal{UserUUID: us.New()}
+	admin := &auth.Principal{UserUUID: us.New(), Roles: []string{auth.RoleAdmin}, Permissions: []string{auth.PermissionUsersRead, auth.PermissionUsersCreate, auth.PermissionUsersUpdate, auth.PermissionUsersDelete}}
+	ownRecord := &auth.Resource{OwnerUUID: user.UserUUID}
+	otherRecord := &auth.Resource{OwnerUUID: us.New()}
+
+	var tests = []struct {
+		name      string
+		principal *auth.Principal
+		action    auth.Action
+		resource  *auth.Resource
+		wantValid bool
+	}{
+		{"User updates own record", user, auth.ActionUsersUpdate, ownRecord, true},
+		{"User deletes own record", user, auth.ActionUsersDelete, ownRecord, true},
+		{"User updates other user's record", user, auth.ActionUsersUpdate, otherRecord, false},
+		{"User deletes other user's record", user, auth.ActionUsersDelete, otherRecord, false},
+		{"User creates user", user, auth.ActionUsersCreate, nil, false},
+		{"User lists users", user, auth.ActionUsersRead, nil, false},
+		{"Admin updates other user's record", admin, auth.ActionUsersUpdate, otherRecord, true},
+		{"Admin deletes other user's record", admin, auth.ActionUsersDelete, otherRecord, true},
+		{"Admin creates user", admin, auth.ActionUsersCreate, nil, true},
+		{"Admin lists users", admin, auth.ActionUsersRead, nil, true},
+		{"Action without rule", admin, auth.Action("unknown"), ownRecord, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ps.Authorize(tt.principal, tt.action, tt.resource)
+			isValid := err == nil
+
+			if isValid != tt.wantValid {
+				t.Errorf("got valid %v, want valid %v (%v)", isValid, tt.wantValid, err)
+				return
+			}
+			if !isValid && !errors.Is(err, common.ErrAccessDenied) {
+				t.Errorf("got error %v, want %v", err, common.ErrAccessDenied)
+			}
+		})
+	}
+}
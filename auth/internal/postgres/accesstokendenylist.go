@@ -0,0 +1,58 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	auth "github.com/medods-technical-assessment"
+)
+
+// AccessTokenDenylist represents a PostgreSQL implementation of auth.AccessTokenDenylist,
+// shared by all instances of the service
+type AccessTokenDenylist struct {
+	DB *sql.DB
+}
+
+func NewAccessTokenDenylist(db *sql.DB) *AccessTokenDenylist {
+	return &AccessTokenDenylist{
+		DB: db,
+	}
+}
+
+func (d *AccessTokenDenylist) Add(jti auth.UUID, expiresAt time.Time) error {
+	// Entries of expired tokens are of no use, so they are purged along the way
+	query := `
+        WITH purged AS (
+            DELETE FROM revoked_access_tokens
+            WHERE expires_at < NOW()
+        )
+        INSERT INTO revoked_access_tokens (jti, expires_at)
+        VALUES ($1, $2)
+        ON CONFLICT (jti) DO NOTHING`
+
+	_, err := d.DB.Exec(query, jti, expiresAt)
+	if err != nil {
+		return fmt.Errorf("error adding revoked access token: %w", err)
+	}
+
+	return nil
+}
+
+func (d *AccessTokenDenylist) Contains(jti auth.UUID) (bool, error) {
+	var exists bool
+	query := `
+        SELECT EXISTS (
+            SELECT 1
+            FROM revoked_access_tokens
+            WHERE jti = $1 AND
+			      expires_at >= NOW()
+        )`
+
+	err := d.DB.QueryRow(query, jti).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("error checking revoked access token: %w", err)
+	}
+
+	return exists, nil
+}
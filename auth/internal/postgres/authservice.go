@@ -44,23 +44,63 @@ func Open(host, port, dbname, user, password string) (*sql.DB, error) {
 	if err := tables.CreateRefreshTokensTable(db); err != nil {
 		log.Panic(err)
 	}
+	if err := tables.CreateSecurityEventsTable(db); err != nil {
+		log.Panic(err)
+	}
+	if err := tables.CreateRevokedAccessTokensTable(db); err != nil {
+		log.Panic(err)
+	}
+	if err := tables.CreateRolesTables(db); err != nil {
+		log.Panic(err)
+	}
+	if err := tables.CreateOneTimeTokensTable(db); err != nil {
+		log.Panic(err)
+	}
+	if err := tables.CreateTOTPCredentialsTable(db); err != nil {
+		log.Panic(err)
+	}
+	if err := tables.CreateRecoveryCodesTable(db); err != nil {
+		log.Panic(err)
+	}
+	if err := tables.CreateWebAuthnCredentialsTable(db); err != nil {
+		log.Panic(err)
+	}
+	if err := tables.CreateWebAuthnSessionsTable(db); err != nil {
+		log.Panic(err)
+	}
+	if err := tables.CreateRateLimitCountersTable(db); err != nil {
+		log.Panic(err)
+	}
 
 	return db, err
 
 }
 
-func (s *AuthService) GetUser(uuid auth.UUID) (*auth.User, error) {
-	user := &auth.User{}
-	query := `
-        SELECT uuid, email, password
-        FROM users
-        WHERE uuid = $1`
+const userColumns = `uuid, email, password, email_verified, password_changed_at, locked_until, failed_login_attempts, last_failed_login_ip, last_failed_login_at`
 
-	err := s.DB.QueryRow(query, uuid).Scan(
+func scanUser(row rowScanner) (*auth.User, error) {
+	user := &auth.User{}
+	err := row.Scan(
 		&user.UUID,
 		&user.Email,
 		&user.Password,
+		&user.EmailVerified,
+		&user.PasswordChangedAt,
+		&user.LockedUntil,
+		&user.FailedLoginAttempts,
+		&user.LastFailedLoginIP,
+		&user.LastFailedLoginAt,
 	)
+	return user, err
+}
+
+func (s *AuthService) GetUser(uuid auth.UUID) (*auth.User, error) {
+	query := `
+        SELECT ` + userColumns + `
+        FROM users
+        WHERE uuid = $1`
+
+	user, err := scanUser(s.DB.QueryRow(query, uuid))
 
 	if err == sql.ErrNoRows {
 		return nil, fmt.Errorf("user not found: %w", err)
@@ -72,17 +112,12 @@ func (s *AuthService) GetUser(uuid auth.UUID) (*auth.User, error) {
 }
 
 func (s *AuthService) GetUserByEmail(email string) (*auth.User, error) {
-	user := &auth.User{}
 	query := `
-        SELECT uuid, email, password
+        SELECT ` + userColumns + `
         FROM users
         WHERE email = $1`
 
-	err := s.DB.QueryRow(query, email).Scan(
-		&user.UUID,
-		&user.Email,
-		&user.Password,
-	)
+	user, err := scanUser(s.DB.QueryRow(query, email))
 
 	if err == sql.ErrNoRows {
 		return nil, fmt.Errorf("user with email %v not found: %w", email, err)
@@ -96,7 +131,7 @@ func (s *AuthService) GetUserByEmail(email string) (*auth.User, error) {
 func (s *AuthService) GetUsers() ([]*auth.User, error) {
 	users := make([]*auth.User, 0)
 	query := `
-        SELECT uuid, email, password 
+        SELECT ` + userColumns + `
         FROM users`
 
 	rows, err := s.DB.Query(query)
@@ -106,12 +141,7 @@ func (s *AuthService) GetUsers() ([]*auth.User, error) {
 	defer rows.Close()
 
 	for rows.Next() {
-		user := &auth.User{}
-		err := rows.Scan(
-			&user.UUID,
-			&user.Email,
-			&user.Password,
-		)
+		user, err := scanUser(rows)
 		if err != nil {
 			return nil, fmt.Errorf("error scanning user: %w", err)
 		}
@@ -127,20 +157,18 @@ func (s *AuthService) GetUsers() ([]*auth.User, error) {
 
 func (s *AuthService) CreateUser(user *auth.User) (*auth.User, error) {
 	query := `
-        INSERT INTO users (uuid, email, password)
-        VALUES ($1, $2, $3)
-        RETURNING uuid, email, password`
+        INSERT INTO users (uuid, email, password, email_verified, password_changed_at)
+        VALUES ($1, $2, $3, $4, $5)
+        RETURNING ` + userColumns
 
-	err := s.DB.QueryRow(
+	user, err := scanUser(s.DB.QueryRow(
 		query,
 		user.UUID,
 		user.Email,
 		user.Password,
-	).Scan(
-		&user.UUID,
-		&user.Email,
-		&user.Password,
-	)
+		user.EmailVerified,
+		user.PasswordChangedAt,
+	))
 
 	if err != nil {
 		if pqErr, ok := err.(*pq.Error); ok {
@@ -162,20 +190,20 @@ func (s *AuthService) UpdateUser(user *auth.User) (*auth.User, error) {
 	query := `
         UPDATE users
 		SET email = $2,
-			password = $3
+			password = $3,
+			email_verified = $4,
+			password_changed_at = $5
 		WHERE uuid = $1
-		RETURNING uuid, email, password`
+		RETURNING ` + userColumns
 
-	err := s.DB.QueryRow(
+	user, err := scanUser(s.DB.QueryRow(
 		query,
 		user.UUID,
 		user.Email,
 		user.Password,
-	).Scan(
-		&user.UUID,
-		&user.Email,
-		&user.Password,
-	)
+		user.EmailVerified,
+		user.PasswordChangedAt,
+	))
 
 	if err != nil {
 		if pqErr, ok := err.(*pq.Error); ok {
@@ -213,88 +241,3 @@ func (s *AuthService) DeleteUser(uuid auth.UUID) error {
 	}
 	return err
 }
-
-func (s *AuthService) AddRefreshToken(refreshToken *auth.RefreshToken) error {
-	query := `
-        INSERT INTO refresh_tokens (uuid, hashed_token, user_uuid, active, created_at)
-        VALUES ($1, $2, $3, $4, $5)`
-
-	_, err := s.DB.Exec(
-		query,
-		refreshToken.UUID,
-		refreshToken.HashedToken,
-		refreshToken.UserUUID,
-		refreshToken.Active,
-		refreshToken.CreatedAt,
-	)
-
-	if err != nil {
-		return fmt.Errorf("error adding refresh token: %w", err)
-	}
-
-	return nil
-}
-
-func (s *AuthService) RevokeRefreshTokensByUser(userUUID auth.UUID) error {
-	query := `
-		UPDATE refresh_tokens
-		SET active = false
-		WHERE user_uuid = $1`
-
-	_, err := s.DB.Exec(query, userUUID)
-
-	if err != nil {
-		return fmt.Errorf("error while revoking refresh tokens for user: %w", err)
-	}
-
-	return nil
-}
-func (s *AuthService) GetActiveRefreshTokenByUser(userUUID auth.UUID) (*auth.RefreshToken, error) {
-	refreshToken := &auth.RefreshToken{}
-	query := `
-        SELECT uuid, hashed_token, user_uuid, active, created_at
-        FROM refresh_tokens
-        WHERE user_uuid = $1 AND
-			  active = true`
-
-	err := s.DB.QueryRow(query, userUUID).Scan(
-		&refreshToken.UUID,
-		&refreshToken.HashedToken,
-		&refreshToken.UserUUID,
-		&refreshToken.Active,
-		&refreshToken.CreatedAt,
-	)
-	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("refresh token not found: %w", err)
-	}
-	if err != nil {
-		return nil, fmt.Errorf("error getting refresh token: %w", err)
-	}
-
-	return refreshToken, nil
-}
-
-func (s *AuthService) GetActiveRefreshToken(uuid auth.UUID) (*auth.RefreshToken, error) {
-	refreshToken := &auth.RefreshToken{}
-	query := `
-        SELECT uuid, hashed_token, user_uuid, active, created_at
-        FROM refresh_tokens
-        WHERE uuid = $1 AND
-			  active = true`
-
-	err := s.DB.QueryRow(query, uuid).Scan(
-		&refreshToken.UUID,
-		&refreshToken.HashedToken,
-		&refreshToken.UserUUID,
-		&refreshToken.Active,
-		&refreshToken.CreatedAt,
-	)
-	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("refresh token not found: %w", err)
-	}
-	if err != nil {
-		return nil, fmt.Errorf("error getting refresh token: %w", err)
-	}
-
-	return refreshToken, nil
-}
@@ -0,0 +1,133 @@
+package postgres
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	auth "github.com/medods-technical-assessment"
+)
+
+// Driver which checks inserts the way postgres would and echoes the inserted values back
+type insertCheckingDriver struct{}
+
+func (insertCheckingDriver) Open(name string) (driver.Conn, error) { return insertCheckingConn{}, nil }
+
+type insertCheckingConn struct{}
+
+func (insertCheckingConn) Prepare(query string) (driver.Stmt, error) {
+	return insertCheckingStmt{query: query}, nil
+}
+func (insertCheckingConn) Close() error              { return nil }
+func (insertCheckingConn) Begin() (driver.Tx, error) { return nil, fmt.Errorf("not supported") }
+
+type insertCheckingStmt struct {
+	query string
+}
+
+var (
+	insertColumnsRegexp = regexp.MustCompile(`INSERT INTO \w+ \(([^)]*)\)`)
+	placeholderRegexp   = regexp.MustCompile(`\$\d+`)
+	returningRegexp     = regexp.MustCompile(`RETURNING (.*)$`)
+)
+
+func (insertCheckingStmt) Close() error  { return nil }
+func (insertCheckingStmt) NumInput() int { return -1 }
+func (insertCheckingStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return nil, fmt.Errorf("not supported")
+}
+
+func (s insertCheckingStmt) Query(args []driver.Value) (driver.Rows, error) {
+	query := strings.Join(strings.Fields(s.query), " ")
+
+	match := insertColumnsRegexp.FindStringSubmatch(query)
+	if match == nil {
+		return nil, fmt.Errorf("not an insert: %s", query)
+	}
+	columns := strings.Split(match[1], ",")
+	placeholders := placeholderRegexp.FindAllString(query, -1)
+	if len(columns) != len(placeholders) {
+		return nil, fmt.Errorf("insert has %d target columns and %d expressions", len(columns), len(placeholders))
+	}
+	if len(placeholders) != len(args) {
+		return nil, fmt.Errorf("insert has %d expressions and %d arguments", len(placeholders), len(args))
+	}
+
+	values := make(map[string]driver.Value)
+	for i, column := range columns {
+		values[strings.TrimSpace(column)] = args[i]
+	}
+	defaults := map[string]driver.Value{"failed_login_attempts": int64(0), "last_failed_login_ip": ""}
+
+	rows := &insertCheckingRows{}
+	for _, column := range strings.Split(returningRegexp.FindStringSubmatch(query)[1], ",") {
+		column = strings.TrimSpace(column)
+		rows.columns = append(rows.columns, column)
+		if value, ok := values[column]; ok {
+			rows.values = append(rows.values, value)
+		} else {
+			rows.values = append(rows.values, defaults[column])
+		}
+	}
+
+	return rows, nil
+}
+
+type insertCheckingRows struct {
+	columns []string
+	values  []driver.Value
+	done    bool
+}
+
+func (r *insertCheckingRows) Columns() []string { return r.columns }
+func (r *insertCheckingRows) Close() error      { return nil }
+func (r *insertCheckingRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	copy(dest, r.values)
+	return nil
+}
+
+func init() {
+	sql.Register("postgres-insert-checking", insertCheckingDriver{})
+}
+
+func TestCreateUser(t *testing.T) {
+	db, err := sql.Open("postgres-insert-checking", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	passwordChangedAt := time.Now().UTC().Truncate(time.Second)
+	user := &auth.User{
+		UUID:              uuid.New(),
+		Email:             "user@example.com",
+		Password:          "hash",
+		EmailVerified:     true,
+		PasswordChangedAt: &passwordChangedAt,
+	}
+
+	got, err := NewAuthService(db).CreateUser(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.UUID != user.UUID || got.Email != user.Email || got.Password != user.Password || got.EmailVerified != user.EmailVerified {
+		t.Errorf("got user %+v, want user %+v", got, user)
+	}
+	if got.PasswordChangedAt == nil || !got.PasswordChangedAt.Equal(passwordChangedAt) {
+		t.Errorf("got password changed at %v, want password changed at %v", got.PasswordChangedAt, passwordChangedAt)
+	}
+	if got.LockedUntil != nil || got.FailedLoginAttempts != 0 {
+		t.Errorf("got lockout %v, %d, want no lockout", got.LockedUntil, got.FailedLoginAttempts)
+	}
+}
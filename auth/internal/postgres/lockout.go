@@ -0,0 +1,79 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	auth "github.com/medods-technical-assessment"
+)
+
+// Increments the counter in a single statement, so that concurrent failures are all counted
+func (s *AuthService) RecordFailedLogin(userUUID auth.UUID, ip string, at time.Time) (int, error) {
+	query := `
+        UPDATE users
+        SET failed_login_attempts = failed_login_attempts + 1,
+            last_failed_login_ip = $2,
+            last_failed_login_at = $3
+        WHERE uuid = $1
+        RETURNING failed_login_attempts`
+
+	var attempts int
+	err := s.DB.QueryRow(query, userUUID, ip, at).Scan(&attempts)
+
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("error recording failed login: user not found")
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error recording failed login: %w", err)
+	}
+
+	return attempts, nil
+}
+
+func (s *AuthService) LockUser(userUUID auth.UUID, until time.Time) error {
+	query := `
+        UPDATE users
+        SET locked_until = $2,
+            failed_login_attempts = 0
+        WHERE uuid = $1`
+
+	result, err := s.DB.Exec(query, userUUID, until)
+	if err != nil {
+		return fmt.Errorf("error locking user: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error checking affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("error locking user: user not found")
+	}
+
+	return nil
+}
+
+// Keeps the ip and time of the last failure, so that support can still see them
+func (s *AuthService) UnlockUser(userUUID auth.UUID) error {
+	query := `
+        UPDATE users
+        SET locked_until = NULL,
+            failed_login_attempts = 0
+        WHERE uuid = $1`
+
+	result, err := s.DB.Exec(query, userUUID)
+	if err != nil {
+		return fmt.Errorf("error unlocking user: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error checking affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("error unlocking user: user not found")
+	}
+
+	return nil
+}
@@ -0,0 +1,111 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+
+	auth "github.com/medods-technical-assessment"
+	"github.com/medods-technical-assessment/internal/common"
+)
+
+const oneTimeTokenColumns = `uuid, user_uuid, purpose, email, hashed_token, expires_at, used_at, created_at`
+
+func (s *AuthService) AddOneTimeToken(token *auth.OneTimeToken) error {
+	// Expired tokens can't be used anymore, so they are purged along the way
+	query := `
+        WITH purged AS (
+            DELETE FROM one_time_tokens
+            WHERE expires_at < NOW()
+        )
+        INSERT INTO one_time_tokens (` + oneTimeTokenColumns + `)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+
+	_, err := s.DB.Exec(
+		query,
+		token.UUID,
+		token.UserUUID,
+		token.Purpose,
+		token.Email,
+		token.HashedToken,
+		token.ExpiresAt,
+		token.UsedAt,
+		token.CreatedAt,
+	)
+
+	if err != nil {
+		return fmt.Errorf("error adding one-time token: %w", err)
+	}
+
+	return nil
+}
+
+func (s *AuthService) GetOneTimeToken(uuid auth.UUID) (*auth.OneTimeToken, error) {
+	query := `
+        SELECT ` + oneTimeTokenColumns + `
+        FROM one_time_tokens
+        WHERE uuid = $1`
+
+	token := &auth.OneTimeToken{}
+	err := s.DB.QueryRow(query, uuid).Scan(
+		&token.UUID,
+		&token.UserUUID,
+		&token.Purpose,
+		&token.Email,
+		&token.HashedToken,
+		&token.ExpiresAt,
+		&token.UsedAt,
+		&token.CreatedAt,
+	)
+
+	if err == sql.ErrNoRows {
+		return nil, common.ErrOneTimeTokenNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error fetching one-time token: %w", err)
+	}
+
+	return token, nil
+}
+
+// Marks the token used, unless it has already been used or has expired. Done in a single
+// statement, so that concurrent requests can't both use the same token
+func (s *AuthService) UseOneTimeToken(uuid auth.UUID) error {
+	query := `
+        UPDATE one_time_tokens
+        SET used_at = NOW()
+        WHERE uuid = $1 AND
+              used_at IS NULL AND
+              expires_at >= NOW()`
+
+	result, err := s.DB.Exec(query, uuid)
+	if err != nil {
+		return fmt.Errorf("error using one-time token: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error checking affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return common.ErrOneTimeTokenNotFound
+	}
+
+	return nil
+}
+
+// Deletes user's tokens with the purpose which haven't been used yet, e.g. other reset links
+// once the password has been reset
+func (s *AuthService) DeleteOneTimeTokensByUser(userUUID auth.UUID, purpose auth.ActionPurpose) error {
+	query := `
+        DELETE FROM one_time_tokens
+        WHERE user_uuid = $1 AND
+              purpose = $2 AND
+              used_at IS NULL`
+
+	_, err := s.DB.Exec(query, userUUID, purpose)
+	if err != nil {
+		return fmt.Errorf("error deleting one-time tokens: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,138 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	auth "github.com/medods-technical-assessment"
+)
+
+// RateLimitStore represents a PostgreSQL implementation of auth.RateLimitStore,
+// shared by all instances of the service
+type RateLimitStore struct {
+	DB *sql.DB
+}
+
+func NewRateLimitStore(db *sql.DB) *RateLimitStore {
+	return &RateLimitStore{
+		DB: db,
+	}
+}
+
+// Shifts the windows and increments the counter in a single statement, so that
+// concurrent hits are all counted
+func (s *RateLimitStore) Hit(key string, window time.Duration, at time.Time) (*auth.RateLimitCounter, error) {
+	windowStart := at.Truncate(window)
+
+	// Counters are of no use once the previous window no longer overlaps the sliding one,
+	// so they are purged along the way. The hit key is left to the upsert, as a row can't be
+	// modified twice by a single statement
+	query := `
+        WITH purged AS (
+            DELETE FROM rate_limit_counters
+            WHERE expires_at < NOW() AND
+                  key <> $1
+        )
+        INSERT INTO rate_limit_counters AS c (key, window_start, current_count, previous_count, last_hit_at, expires_at)
+        VALUES ($1, $2, 1, 0, $4, $5)
+        ON CONFLICT (key) DO UPDATE
+        SET previous_count = CASE
+                WHEN c.window_start = $2 THEN c.previous_count
+                WHEN c.window_start = $3 THEN c.current_count
+                ELSE 0
+            END,
+            current_count = CASE
+                WHEN c.window_start = $2 THEN c.current_count + 1
+                ELSE 1
+            END,
+            window_start = $2,
+            previous_hit_at = c.last_hit_at,
+            last_hit_at = $4,
+            expires_at = $5
+        RETURNING window_start, current_count, previous_count, last_hit_at, previous_hit_at`
+
+	counter := &auth.RateLimitCounter{}
+	var previousHitAt sql.NullTime
+	err := s.DB.QueryRow(
+		query,
+		key,
+		windowStart,
+		windowStart.Add(-window),
+		at,
+		windowStart.Add(2*window),
+	).Scan(
+		&counter.WindowStart,
+		&counter.Current,
+		&counter.Previous,
+		&counter.LastHitAt,
+		&previousHitAt,
+	)
+
+	if err != nil {
+		return nil, fmt.Errorf("error hitting rate limit counter: %w", err)
+	}
+	if previousHitAt.Valid {
+		counter.PreviousHitAt = previousHitAt.Time
+	}
+
+	return counter, nil
+}
+
+func (s *RateLimitStore) Get(key string) (*auth.RateLimitCounter, error) {
+	query := `
+        SELECT window_start, current_count, previous_count, last_hit_at, previous_hit_at
+        FROM rate_limit_counters
+        WHERE key = $1 AND
+              expires_at >= NOW()`
+
+	counter := &auth.RateLimitCounter{}
+	var previousHitAt sql.NullTime
+	err := s.DB.QueryRow(query, key).Scan(
+		&counter.WindowStart,
+		&counter.Current,
+		&counter.Previous,
+		&counter.LastHitAt,
+		&previousHitAt,
+	)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error fetching rate limit counter: %w", err)
+	}
+	if previousHitAt.Valid {
+		counter.PreviousHitAt = previousHitAt.Time
+	}
+
+	return counter, nil
+}
+
+func (s *RateLimitStore) Undo(key string) error {
+	query := `
+        UPDATE rate_limit_counters
+        SET current_count = current_count - 1
+        WHERE key = $1 AND
+              current_count > 0`
+
+	_, err := s.DB.Exec(query, key)
+	if err != nil {
+		return fmt.Errorf("error undoing rate limit hit: %w", err)
+	}
+
+	return nil
+}
+
+func (s *RateLimitStore) Reset(key string) error {
+	query := `
+        DELETE FROM rate_limit_counters
+        WHERE key = $1`
+
+	_, err := s.DB.Exec(query, key)
+	if err != nil {
+		return fmt.Errorf("error resetting rate limit counter: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,136 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/lib/pq"
+	auth "github.com/medods-technical-assessment"
+	"github.com/medods-technical-assessment/internal/common"
+)
+
+// Replaces all recovery codes of the user, used or not, in a single transaction,
+// so that the user never ends up with both sets or with none
+func (s *AuthService) ReplaceRecoveryCodes(userUUID auth.UUID, codes []*auth.RecoveryCode) error {
+	uuids := make([]string, 0, len(codes))
+	lookups := make([]string, 0, len(codes))
+	hashedCodes := make([]string, 0, len(codes))
+	for _, code := range codes {
+		uuids = append(uuids, code.UUID.String())
+		lookups = append(lookups, code.Lookup)
+		hashedCodes = append(hashedCodes, code.HashedCode)
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Sub-statements of a single query see the same snapshot, so the new codes would collide
+	// with lookups of the old ones being deleted, hence the separate statements
+	query := `
+        DELETE FROM recovery_codes
+        WHERE user_uuid = $1`
+
+	if _, err = tx.Exec(query, userUUID); err != nil {
+		return fmt.Errorf("error deleting recovery codes: %w", err)
+	}
+
+	query = `
+        INSERT INTO recovery_codes (uuid, user_uuid, lookup, hashed_code, created_at)
+        SELECT code.uuid, $1, code.lookup, code.hashed_code, NOW()
+        FROM unnest($2::uuid[], $3::text[], $4::text[]) AS code(uuid, lookup, hashed_code)`
+
+	if _, err = tx.Exec(query, userUUID, pq.Array(uuids), pq.Array(lookups), pq.Array(hashedCodes)); err != nil {
+		return fmt.Errorf("error adding recovery codes: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("error committing recovery codes replacement: %w", err)
+	}
+
+	return nil
+}
+
+// Returns the unused code of the user, which starts with the lookup
+func (s *AuthService) GetRecoveryCodeByLookup(userUUID auth.UUID, lookup string) (*auth.RecoveryCode, error) {
+	query := `
+        SELECT uuid, user_uuid, lookup, hashed_code, used_at, created_at
+        FROM recovery_codes
+        WHERE user_uuid = $1 AND
+              lookup = $2 AND
+              used_at IS NULL`
+
+	code := &auth.RecoveryCode{}
+	err := s.DB.QueryRow(query, userUUID, lookup).Scan(
+		&code.UUID,
+		&code.UserUUID,
+		&code.Lookup,
+		&code.HashedCode,
+		&code.UsedAt,
+		&code.CreatedAt,
+	)
+
+	if err == sql.ErrNoRows {
+		return nil, common.ErrRecoveryCodeNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error fetching recovery code: %w", err)
+	}
+
+	return code, nil
+}
+
+// Marks the code used, unless it already has been, e.g. by a concurrent request
+func (s *AuthService) UseRecoveryCode(uuid auth.UUID) error {
+	query := `
+        UPDATE recovery_codes
+        SET used_at = NOW()
+        WHERE uuid = $1 AND
+              used_at IS NULL`
+
+	result, err := s.DB.Exec(query, uuid)
+	if err != nil {
+		return fmt.Errorf("error using recovery code: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error checking affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return common.ErrRecoveryCodeNotFound
+	}
+
+	return nil
+}
+
+func (s *AuthService) CountUnusedRecoveryCodes(userUUID auth.UUID) (int, error) {
+	var count int
+	query := `
+        SELECT COUNT(*)
+        FROM recovery_codes
+        WHERE user_uuid = $1 AND
+              used_at IS NULL`
+
+	err := s.DB.QueryRow(query, userUUID).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("error counting recovery codes: %w", err)
+	}
+
+	return count, nil
+}
+
+func (s *AuthService) DeleteRecoveryCodesByUser(userUUID auth.UUID) error {
+	query := `
+        DELETE FROM recovery_codes
+        WHERE user_uuid = $1`
+
+	_, err := s.DB.Exec(query, userUUID)
+	if err != nil {
+		return fmt.Errorf("error deleting recovery codes: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,338 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	auth "github.com/medods-technical-assessment"
+	"github.com/medods-technical-assessment/internal/common"
+)
+
+const refreshTokenColumns = `uuid, hashed_token, user_uuid, family_uuid, parent_uuid, ip, user_agent, scope, active, created_at`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanRefreshToken(row rowScanner) (*auth.RefreshToken, error) {
+	refreshToken := &auth.RefreshToken{}
+	err := row.Scan(
+		&refreshToken.UUID,
+		&refreshToken.HashedToken,
+		&refreshToken.UserUUID,
+		&refreshToken.FamilyUUID,
+		&refreshToken.ParentUUID,
+		&refreshToken.IP,
+		&refreshToken.UserAgent,
+		&refreshToken.Scope,
+		&refreshToken.Active,
+		&refreshToken.CreatedAt,
+	)
+	return refreshToken, err
+}
+
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+func (s *AuthService) AddRefreshToken(refreshToken *auth.RefreshToken) error {
+	return addRefreshToken(s.DB, refreshToken)
+}
+
+func addRefreshToken(db execer, refreshToken *auth.RefreshToken) error {
+	query := `
+        INSERT INTO refresh_tokens (` + refreshTokenColumns + `)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`
+
+	_, err := db.Exec(
+		query,
+		refreshToken.UUID,
+		refreshToken.HashedToken,
+		refreshToken.UserUUID,
+		refreshToken.FamilyUUID,
+		refreshToken.ParentUUID,
+		refreshToken.IP,
+		refreshToken.UserAgent,
+		refreshToken.Scope,
+		refreshToken.Active,
+		refreshToken.CreatedAt,
+	)
+
+	if err != nil {
+		return fmt.Errorf("error adding refresh token: %w", err)
+	}
+
+	return nil
+}
+
+// Replaces the active token with the new one in a single transaction. Of concurrent rotations
+// of the same token only one deactivates it, the others wait for it and get ErrRefreshTokenNotActive
+func (s *AuthService) RotateRefreshToken(refreshTokenUUID auth.UUID, newRefreshToken *auth.RefreshToken) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE refresh_tokens
+		SET active = false
+		WHERE uuid = $1 AND
+			  active = true
+		RETURNING uuid`
+
+	var rotatedUUID auth.UUID
+	err = tx.QueryRow(query, refreshTokenUUID).Scan(&rotatedUUID)
+	if err == sql.ErrNoRows {
+		return common.ErrRefreshTokenNotActive
+	}
+	if err != nil {
+		return fmt.Errorf("error deactivating refresh token: %w", err)
+	}
+
+	if err = addRefreshToken(tx, newRefreshToken); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("error committing refresh token rotation: %w", err)
+	}
+
+	return nil
+}
+
+func (s *AuthService) RevokeRefreshTokensByUser(userUUID auth.UUID) error {
+	query := `
+		UPDATE refresh_tokens
+		SET active = false
+		WHERE user_uuid = $1`
+
+	_, err := s.DB.Exec(query, userUUID)
+
+	if err != nil {
+		return fmt.Errorf("error while revoking refresh tokens for user: %w", err)
+	}
+
+	return nil
+}
+
+func (s *AuthService) RevokeRefreshTokenFamily(userUUID auth.UUID, familyUUID auth.UUID) error {
+	query := `
+		UPDATE refresh_tokens
+		SET active = false
+		WHERE user_uuid = $1 AND
+			  family_uuid = $2 AND
+			  active = true`
+
+	result, err := s.DB.Exec(query, userUUID, familyUUID)
+
+	if err != nil {
+		return fmt.Errorf("error while revoking refresh token family: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error checking affected rows: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return common.ErrRefreshTokenFamilyNotFound
+	}
+
+	return nil
+}
+
+func (s *AuthService) RevokeOtherRefreshTokenFamilies(userUUID auth.UUID, familyUUID auth.UUID) error {
+	query := `
+		UPDATE refresh_tokens
+		SET active = false
+		WHERE user_uuid = $1 AND
+			  family_uuid <> $2`
+
+	_, err := s.DB.Exec(query, userUUID, familyUUID)
+
+	if err != nil {
+		return fmt.Errorf("error while revoking other refresh token families: %w", err)
+	}
+
+	return nil
+}
+
+func (s *AuthService) GetSessionsByUser(userUUID auth.UUID) ([]*auth.Session, error) {
+	sessions := make([]*auth.Session, 0)
+	// Session is described by its active token, while its creation time is the one of the family's first token
+	query := `
+        SELECT t.family_uuid, t.ip, t.user_agent, f.created_at, t.created_at
+        FROM refresh_tokens t
+        JOIN (
+            SELECT family_uuid, MIN(created_at) AS created_at
+            FROM refresh_tokens
+            WHERE user_uuid = $1
+            GROUP BY family_uuid
+        ) f ON f.family_uuid = t.family_uuid
+        WHERE t.user_uuid = $1 AND
+			  t.active = true
+		ORDER BY t.created_at DESC`
+
+	rows, err := s.DB.Query(query, userUUID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching sessions: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		session := &auth.Session{}
+		err := rows.Scan(
+			&session.UUID,
+			&session.IP,
+			&session.UserAgent,
+			&session.CreatedAt,
+			&session.RefreshedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning session: %w", err)
+		}
+		sessions = append(sessions, session)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating sessions: %w", err)
+	}
+
+	return sessions, nil
+}
+
+func (s *AuthService) GetActiveRefreshTokensByUser(userUUID auth.UUID) ([]*auth.RefreshToken, error) {
+	refreshTokens := make([]*auth.RefreshToken, 0)
+	query := `
+        SELECT ` + refreshTokenColumns + `
+        FROM refresh_tokens
+        WHERE user_uuid = $1 AND
+			  active = true
+		ORDER BY created_at DESC`
+
+	rows, err := s.DB.Query(query, userUUID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching refresh tokens: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		refreshToken, err := scanRefreshToken(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning refresh token: %w", err)
+		}
+		refreshTokens = append(refreshTokens, refreshToken)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating refresh tokens: %w", err)
+	}
+
+	return refreshTokens, nil
+}
+
+// Returns tokens issued since the time regardless of whether they are still active,
+// e.g. to find access tokens which haven't expired yet
+func (s *AuthService) GetRefreshTokensByUserSince(userUUID auth.UUID, since time.Time) ([]*auth.RefreshToken, error) {
+	refreshTokens := make([]*auth.RefreshToken, 0)
+	query := `
+        SELECT ` + refreshTokenColumns + `
+        FROM refresh_tokens
+        WHERE user_uuid = $1 AND
+			  created_at >= $2
+		ORDER BY created_at DESC`
+
+	rows, err := s.DB.Query(query, userUUID, since)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching refresh tokens: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		refreshToken, err := scanRefreshToken(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning refresh token: %w", err)
+		}
+		refreshTokens = append(refreshTokens, refreshToken)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating refresh tokens: %w", err)
+	}
+
+	return refreshTokens, nil
+}
+
+func (s *AuthService) GetActiveRefreshToken(uuid auth.UUID) (*auth.RefreshToken, error) {
+	query := `
+        SELECT ` + refreshTokenColumns + `
+        FROM refresh_tokens
+        WHERE uuid = $1 AND
+			  active = true`
+
+	refreshToken, err := scanRefreshToken(s.DB.QueryRow(query, uuid))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: %w", common.ErrRefreshTokenNotFound, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting refresh token: %w", err)
+	}
+
+	return refreshToken, nil
+}
+
+// Returns refresh token regardless of whether it is still active
+func (s *AuthService) GetRefreshToken(uuid auth.UUID) (*auth.RefreshToken, error) {
+	query := `
+        SELECT ` + refreshTokenColumns + `
+        FROM refresh_tokens
+        WHERE uuid = $1`
+
+	refreshToken, err := scanRefreshToken(s.DB.QueryRow(query, uuid))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: %w", common.ErrRefreshTokenNotFound, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting refresh token: %w", err)
+	}
+
+	return refreshToken, nil
+}
+
+// Returns the token which replaced the given one during a Refresh operation
+func (s *AuthService) GetRefreshTokenByParent(parentUUID auth.UUID) (*auth.RefreshToken, error) {
+	query := `
+        SELECT ` + refreshTokenColumns + `
+        FROM refresh_tokens
+        WHERE parent_uuid = $1`
+
+	refreshToken, err := scanRefreshToken(s.DB.QueryRow(query, parentUUID))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: %w", common.ErrRefreshTokenNotFound, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting refresh token: %w", err)
+	}
+
+	return refreshToken, nil
+}
+
+// Returns the current token of the session, if the session hasn't been revoked
+func (s *AuthService) GetActiveRefreshTokenByFamily(familyUUID auth.UUID) (*auth.RefreshToken, error) {
+	query := `
+        SELECT ` + refreshTokenColumns + `
+        FROM refresh_tokens
+        WHERE family_uuid = $1 AND
+			  active = true`
+
+	refreshToken, err := scanRefreshToken(s.DB.QueryRow(query, familyUUID))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: %w", common.ErrRefreshTokenNotFound, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting refresh token: %w", err)
+	}
+
+	return refreshToken, nil
+}
@@ -0,0 +1,102 @@
+package postgres
+
+import (
+	"fmt"
+
+	"github.com/lib/pq"
+	auth "github.com/medods-technical-assessment"
+)
+
+func (s *AuthService) GetRolesByUser(userUUID auth.UUID) ([]string, error) {
+	query := `
+        SELECT role
+        FROM user_roles
+        WHERE user_uuid = $1
+        ORDER BY role`
+
+	return s.queryStrings("roles", query, userUUID)
+}
+
+// Returns permissions granted through all of user's roles
+func (s *AuthService) GetPermissionsByUser(userUUID auth.UUID) ([]string, error) {
+	query := `
+        SELECT DISTINCT rp.permission
+        FROM user_roles ur
+        JOIN role_permissions rp ON rp.role = ur.role
+        WHERE ur.user_uuid = $1
+        ORDER BY rp.permission`
+
+	return s.queryStrings("permissions", query, userUUID)
+}
+
+func (s *AuthService) AddUserRole(userUUID auth.UUID, role string) error {
+	query := `
+        INSERT INTO user_roles (user_uuid, role)
+        VALUES ($1, $2)
+        ON CONFLICT DO NOTHING`
+
+	_, err := s.DB.Exec(query, userUUID, role)
+	if err != nil {
+		return fmt.Errorf("error adding role %s to user: %w", role, err)
+	}
+
+	return nil
+}
+
+// Takes the role away from everyone but the users, e.g. to keep admins in sync with the configuration
+func (s *AuthService) RemoveRoleFromOtherUsers(role string, userUUIDs []auth.UUID) ([]auth.UUID, error) {
+	uuids := make([]string, 0, len(userUUIDs))
+	for _, userUUID := range userUUIDs {
+		uuids = append(uuids, userUUID.String())
+	}
+
+	query := `
+        DELETE FROM user_roles
+        WHERE role = $1 AND NOT (user_uuid = ANY($2::uuid[]))
+        RETURNING user_uuid`
+
+	rows, err := s.DB.Query(query, role, pq.Array(uuids))
+	if err != nil {
+		return nil, fmt.Errorf("error removing role %s from users: %w", role, err)
+	}
+	defer rows.Close()
+
+	removed := make([]auth.UUID, 0)
+	for rows.Next() {
+		var userUUID auth.UUID
+		if err = rows.Scan(&userUUID); err != nil {
+			return nil, fmt.Errorf("error scanning user uuid: %w", err)
+		}
+		removed = append(removed, userUUID)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating user uuids: %w", err)
+	}
+
+	return removed, nil
+}
+
+// Fetches single text column of the rows, e.g. names of roles
+func (s *AuthService) queryStrings(name string, query string, args ...any) ([]string, error) {
+	strs := make([]string, 0)
+
+	rows, err := s.DB.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching %s: %w", name, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var str string
+		if err := rows.Scan(&str); err != nil {
+			return nil, fmt.Errorf("error scanning %s: %w", name, err)
+		}
+		strs = append(strs, str)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating %s: %w", name, err)
+	}
+
+	return strs, nil
+}
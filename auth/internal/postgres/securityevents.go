@@ -0,0 +1,30 @@
+package postgres
+
+import (
+	"fmt"
+
+	auth "github.com/medods-technical-assessment"
+)
+
+func (s *AuthService) AddSecurityEvent(event *auth.SecurityEvent) error {
+	query := `
+        INSERT INTO security_events (uuid, user_uuid, type, ip, user_agent, details, created_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)`
+
+	_, err := s.DB.Exec(
+		query,
+		event.UUID,
+		event.UserUUID,
+		event.Type,
+		event.IP,
+		event.UserAgent,
+		event.Details,
+		event.CreatedAt,
+	)
+
+	if err != nil {
+		return fmt.Errorf("error adding security event: %w", err)
+	}
+
+	return nil
+}
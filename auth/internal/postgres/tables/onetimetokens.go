@@ -0,0 +1,34 @@
+package postgres
+
+import (
+	"database/sql"
+
+	_ "github.com/lib/pq"
+)
+
+func CreateOneTimeTokensTable(db *sql.DB) error {
+	query := `
+        CREATE TABLE IF NOT EXISTS one_time_tokens (
+            uuid UUID PRIMARY KEY,
+            user_uuid UUID NOT NULL,
+            purpose TEXT NOT NULL,
+            email TEXT NOT NULL DEFAULT '',
+            hashed_token TEXT NOT NULL,
+            expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+            used_at TIMESTAMP WITH TIME ZONE,
+            created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+            FOREIGN KEY (user_uuid) REFERENCES users(uuid) ON DELETE CASCADE
+        );
+
+        -- Upgrades tables created by earlier versions of the service
+        ALTER TABLE one_time_tokens ADD COLUMN IF NOT EXISTS email TEXT NOT NULL DEFAULT '';
+
+        CREATE INDEX IF NOT EXISTS idx_one_time_tokens_user_uuid
+        ON one_time_tokens (user_uuid, purpose);
+
+        CREATE INDEX IF NOT EXISTS idx_one_time_tokens_expires_at
+        ON one_time_tokens (expires_at);`
+
+	_, err := db.Exec(query)
+	return err
+}
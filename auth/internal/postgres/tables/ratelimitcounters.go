@@ -0,0 +1,26 @@
+package postgres
+
+import (
+	"database/sql"
+
+	_ "github.com/lib/pq"
+)
+
+func CreateRateLimitCountersTable(db *sql.DB) error {
+	query := `
+        CREATE TABLE IF NOT EXISTS rate_limit_counters (
+            key TEXT PRIMARY KEY,
+            window_start TIMESTAMP WITH TIME ZONE NOT NULL,
+            current_count INTEGER NOT NULL,
+            previous_count INTEGER NOT NULL,
+            last_hit_at TIMESTAMP WITH TIME ZONE NOT NULL,
+            previous_hit_at TIMESTAMP WITH TIME ZONE,
+            expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+        );
+
+        CREATE INDEX IF NOT EXISTS idx_rate_limit_counters_expires_at
+        ON rate_limit_counters (expires_at);`
+
+	_, err := db.Exec(query)
+	return err
+}
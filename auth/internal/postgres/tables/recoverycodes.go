@@ -0,0 +1,24 @@
+package postgres
+
+import (
+	"database/sql"
+
+	_ "github.com/lib/pq"
+)
+
+func CreateRecoveryCodesTable(db *sql.DB) error {
+	query := `
+        CREATE TABLE IF NOT EXISTS recovery_codes (
+            uuid UUID PRIMARY KEY,
+            user_uuid UUID NOT NULL,
+            lookup TEXT NOT NULL,
+            hashed_code TEXT NOT NULL,
+            used_at TIMESTAMP WITH TIME ZONE,
+            created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+            FOREIGN KEY (user_uuid) REFERENCES users(uuid) ON DELETE CASCADE,
+            UNIQUE (user_uuid, lookup)
+        );`
+
+	_, err := db.Exec(query)
+	return err
+}
@@ -13,15 +13,39 @@ func CreateRefreshTokensTable(db *sql.DB) error {
             uuid UUID PRIMARY KEY,
             hashed_token TEXT NOT NULL,
             user_uuid UUID NOT NULL,
+            family_uuid UUID NOT NULL,
+            parent_uuid UUID,
+            ip TEXT NOT NULL DEFAULT '',
+            user_agent TEXT NOT NULL DEFAULT '',
+            scope TEXT NOT NULL DEFAULT '',
             active BOOLEAN NOT NULL,
             created_at TIMESTAMP WITH TIME ZONE NOT NULL,
             FOREIGN KEY (user_uuid) REFERENCES users(uuid) ON DELETE CASCADE
         );
-        
-        -- Creates a partial index which ensures there is only ever a single active token per user
-        CREATE UNIQUE INDEX IF NOT EXISTS idx_single_active_token_per_user
-        ON refresh_tokens (user_uuid)
-        WHERE active = true;`
+
+        -- Upgrades tables created by earlier versions of the service
+        -- Before sessions were introduced, each token is considered to be its own family
+        ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS family_uuid UUID;
+        UPDATE refresh_tokens SET family_uuid = uuid WHERE family_uuid IS NULL;
+        ALTER TABLE refresh_tokens ALTER COLUMN family_uuid SET NOT NULL;
+        DROP INDEX IF EXISTS idx_single_active_token_per_user;
+        ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS ip TEXT NOT NULL DEFAULT '';
+        ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS user_agent TEXT NOT NULL DEFAULT '';
+        ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS parent_uuid UUID;
+        -- Sessions started before scopes were introduced have all of them
+        ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS scope TEXT NOT NULL DEFAULT '';
+
+        -- Creates a partial index which ensures there is only ever a single active token per family (session)
+        CREATE UNIQUE INDEX IF NOT EXISTS idx_single_active_token_per_family
+        ON refresh_tokens (family_uuid)
+        WHERE active = true;
+
+        CREATE INDEX IF NOT EXISTS idx_refresh_tokens_user_uuid
+        ON refresh_tokens (user_uuid);
+
+        -- A token can only be rotated once, which is what makes a replayed token detectable
+        CREATE UNIQUE INDEX IF NOT EXISTS idx_refresh_tokens_parent_uuid
+        ON refresh_tokens (parent_uuid);`
 
 	_, err := db.Exec(query)
 	return err
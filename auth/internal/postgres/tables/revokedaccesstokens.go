@@ -0,0 +1,21 @@
+package postgres
+
+import (
+	"database/sql"
+
+	_ "github.com/lib/pq"
+)
+
+func CreateRevokedAccessTokensTable(db *sql.DB) error {
+	query := `
+        CREATE TABLE IF NOT EXISTS revoked_access_tokens (
+            jti UUID PRIMARY KEY,
+            expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+        );
+
+        CREATE INDEX IF NOT EXISTS idx_revoked_access_tokens_expires_at
+        ON revoked_access_tokens (expires_at);`
+
+	_, err := db.Exec(query)
+	return err
+}
@@ -0,0 +1,63 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+
+	_ "github.com/lib/pq"
+	auth "github.com/medods-technical-assessment"
+)
+
+// Permissions of the built-in roles, which are granted on every start
+var builtinRolePermissions = map[string][]string{
+	auth.RoleAdmin: {
+		auth.PermissionUsersRead,
+		auth.PermissionUsersCreate,
+		auth.PermissionUsersUpdate,
+		auth.PermissionUsersDelete,
+		auth.PermissionSessionsManage,
+	},
+}
+
+func CreateRolesTables(db *sql.DB) error {
+	query := `
+        CREATE TABLE IF NOT EXISTS roles (
+            name TEXT PRIMARY KEY
+        );
+
+        CREATE TABLE IF NOT EXISTS role_permissions (
+            role TEXT NOT NULL,
+            permission TEXT NOT NULL,
+            PRIMARY KEY (role, permission),
+            FOREIGN KEY (role) REFERENCES roles(name) ON DELETE CASCADE
+        );
+
+        CREATE TABLE IF NOT EXISTS user_roles (
+            user_uuid UUID NOT NULL,
+            role TEXT NOT NULL,
+            PRIMARY KEY (user_uuid, role),
+            FOREIGN KEY (user_uuid) REFERENCES users(uuid) ON DELETE CASCADE,
+            FOREIGN KEY (role) REFERENCES roles(name) ON DELETE CASCADE
+        );`
+
+	if _, err := db.Exec(query); err != nil {
+		return err
+	}
+
+	for role, permissions := range builtinRolePermissions {
+		if _, err := db.Exec(`INSERT INTO roles (name) VALUES ($1) ON CONFLICT DO NOTHING`, role); err != nil {
+			return fmt.Errorf("error creating role %s: %w", role, err)
+		}
+		for _, permission := range permissions {
+			query := `
+                INSERT INTO role_permissions (role, permission)
+                VALUES ($1, $2)
+                ON CONFLICT DO NOTHING`
+			if _, err := db.Exec(query, role, permission); err != nil {
+				return fmt.Errorf("error granting permission %s to role %s: %w", permission, role, err)
+			}
+		}
+	}
+
+	return nil
+}
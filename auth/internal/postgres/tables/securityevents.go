@@ -0,0 +1,27 @@
+package postgres
+
+import (
+	"database/sql"
+
+	_ "github.com/lib/pq"
+)
+
+func CreateSecurityEventsTable(db *sql.DB) error {
+	query := `
+        CREATE TABLE IF NOT EXISTS security_events (
+            uuid UUID PRIMARY KEY,
+            user_uuid UUID NOT NULL,
+            type TEXT NOT NULL,
+            ip TEXT NOT NULL,
+            user_agent TEXT NOT NULL,
+            details TEXT NOT NULL,
+            created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+            FOREIGN KEY (user_uuid) REFERENCES users(uuid) ON DELETE CASCADE
+        );
+
+        CREATE INDEX IF NOT EXISTS idx_security_events_user_uuid
+        ON security_events (user_uuid, created_at);`
+
+	_, err := db.Exec(query)
+	return err
+}
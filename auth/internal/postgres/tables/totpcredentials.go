@@ -0,0 +1,22 @@
+package postgres
+
+import (
+	"database/sql"
+
+	_ "github.com/lib/pq"
+)
+
+func CreateTOTPCredentialsTable(db *sql.DB) error {
+	query := `
+        CREATE TABLE IF NOT EXISTS totp_credentials (
+            user_uuid UUID PRIMARY KEY,
+            secret TEXT NOT NULL,
+            confirmed BOOLEAN NOT NULL DEFAULT false,
+            last_used_step BIGINT NOT NULL DEFAULT 0,
+            created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+            FOREIGN KEY (user_uuid) REFERENCES users(uuid) ON DELETE CASCADE
+        );`
+
+	_, err := db.Exec(query)
+	return err
+}
@@ -14,8 +14,24 @@ func CreateUsersTable(db *sql.DB) error {
             uuid UUID PRIMARY KEY,
             email TEXT NOT NULL,
             password TEXT NOT NULL,
+            email_verified BOOLEAN NOT NULL DEFAULT false,
+            password_changed_at TIMESTAMP WITH TIME ZONE,
+            locked_until TIMESTAMP WITH TIME ZONE,
+            failed_login_attempts INTEGER NOT NULL DEFAULT 0,
+            last_failed_login_ip TEXT NOT NULL DEFAULT '',
+            last_failed_login_at TIMESTAMP WITH TIME ZONE,
             CONSTRAINT %s UNIQUE (email)
-        );`
+        );
+
+        -- Upgrades tables created by earlier versions of the service
+        -- Users registered before email verification was introduced are considered verified
+        ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified BOOLEAN NOT NULL DEFAULT true;
+        ALTER TABLE users ALTER COLUMN email_verified SET DEFAULT false;
+        ALTER TABLE users ADD COLUMN IF NOT EXISTS password_changed_at TIMESTAMP WITH TIME ZONE;
+        ALTER TABLE users ADD COLUMN IF NOT EXISTS locked_until TIMESTAMP WITH TIME ZONE;
+        ALTER TABLE users ADD COLUMN IF NOT EXISTS failed_login_attempts INTEGER NOT NULL DEFAULT 0;
+        ALTER TABLE users ADD COLUMN IF NOT EXISTS last_failed_login_ip TEXT NOT NULL DEFAULT '';
+        ALTER TABLE users ADD COLUMN IF NOT EXISTS last_failed_login_at TIMESTAMP WITH TIME ZONE;`
 
 	_, err := db.Exec(fmt.Sprintf(query, common.ConstraintUserEmailUnique))
 
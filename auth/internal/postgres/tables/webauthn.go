@@ -0,0 +1,50 @@
+package postgres
+
+import (
+	"database/sql"
+
+	_ "github.com/lib/pq"
+)
+
+func CreateWebAuthnCredentialsTable(db *sql.DB) error {
+	query := `
+        CREATE TABLE IF NOT EXISTS webauthn_credentials (
+            id TEXT PRIMARY KEY,
+            user_uuid UUID NOT NULL,
+            public_key BYTEA NOT NULL,
+            attestation_type TEXT NOT NULL,
+            aaguid UUID NOT NULL,
+            transports TEXT[] NOT NULL DEFAULT '{}',
+            flags SMALLINT NOT NULL,
+            sign_count BIGINT NOT NULL DEFAULT 0,
+            created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+            last_used_at TIMESTAMP WITH TIME ZONE,
+            FOREIGN KEY (user_uuid) REFERENCES users(uuid) ON DELETE CASCADE
+        );
+
+        CREATE INDEX IF NOT EXISTS idx_webauthn_credentials_user_uuid
+        ON webauthn_credentials (user_uuid);`
+
+	_, err := db.Exec(query)
+	return err
+}
+
+// Login ceremonies aren't tied to a user until they finish, so user_uuid is nullable
+func CreateWebAuthnSessionsTable(db *sql.DB) error {
+	query := `
+        CREATE TABLE IF NOT EXISTS webauthn_sessions (
+            uuid UUID PRIMARY KEY,
+            user_uuid UUID,
+            ceremony TEXT NOT NULL,
+            data JSONB NOT NULL,
+            expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+            created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+            FOREIGN KEY (user_uuid) REFERENCES users(uuid) ON DELETE CASCADE
+        );
+
+        CREATE INDEX IF NOT EXISTS idx_webauthn_sessions_expires_at
+        ON webauthn_sessions (expires_at);`
+
+	_, err := db.Exec(query)
+	return err
+}
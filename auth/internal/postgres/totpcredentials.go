@@ -0,0 +1,138 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+
+	auth "github.com/medods-technical-assessment"
+	"github.com/medods-technical-assessment/internal/common"
+)
+
+func (s *AuthService) GetTOTPCredential(userUUID auth.UUID) (*auth.TOTPCredential, error) {
+	query := `
+        SELECT user_uuid, secret, confirmed, last_used_step, created_at
+        FROM totp_credentials
+        WHERE user_uuid = $1`
+
+	credential := &auth.TOTPCredential{}
+	err := s.DB.QueryRow(query, userUUID).Scan(
+		&credential.UserUUID,
+		&credential.Secret,
+		&credential.Confirmed,
+		&credential.LastUsedStep,
+		&credential.CreatedAt,
+	)
+
+	if err == sql.ErrNoRows {
+		return nil, common.ErrTOTPCredentialNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error fetching totp credential: %w", err)
+	}
+
+	return credential, nil
+}
+
+// Replaces credential which hasn't been confirmed yet, e.g. when enrolment is started over.
+// Confirmed credential is left intact
+func (s *AuthService) SaveTOTPCredential(credential *auth.TOTPCredential) error {
+	query := `
+        INSERT INTO totp_credentials (user_uuid, secret, confirmed, last_used_step, created_at)
+        VALUES ($1, $2, $3, $4, $5)
+        ON CONFLICT (user_uuid) DO UPDATE
+        SET secret = EXCLUDED.secret,
+            confirmed = EXCLUDED.confirmed,
+            last_used_step = EXCLUDED.last_used_step,
+            created_at = EXCLUDED.created_at
+        WHERE totp_credentials.confirmed = false`
+
+	result, err := s.DB.Exec(
+		query,
+		credential.UserUUID,
+		credential.Secret,
+		credential.Confirmed,
+		credential.LastUsedStep,
+		credential.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("error saving totp credential: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error checking affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("error saving totp credential: confirmed credential already exists")
+	}
+
+	return nil
+}
+
+func (s *AuthService) ConfirmTOTPCredential(userUUID auth.UUID) error {
+	query := `
+        UPDATE totp_credentials
+        SET confirmed = true
+        WHERE user_uuid = $1`
+
+	result, err := s.DB.Exec(query, userUUID)
+	if err != nil {
+		return fmt.Errorf("error confirming totp credential: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error checking affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return common.ErrTOTPCredentialNotFound
+	}
+
+	return nil
+}
+
+// Records the time step of an accepted code, unless it or a later one has already been used.
+// Done in a single statement, so that the same code can't be accepted by concurrent requests
+func (s *AuthService) UseTOTPStep(userUUID auth.UUID, step int64) error {
+	query := `
+        UPDATE totp_credentials
+        SET last_used_step = $2
+        WHERE user_uuid = $1 AND
+              last_used_step < $2`
+
+	result, err := s.DB.Exec(query, userUUID, step)
+	if err != nil {
+		return fmt.Errorf("error using totp code: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error checking affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return common.ErrTOTPStepUsed
+	}
+
+	return nil
+}
+
+func (s *AuthService) DeleteTOTPCredential(userUUID auth.UUID) error {
+	query := `
+        DELETE FROM totp_credentials
+        WHERE user_uuid = $1`
+
+	result, err := s.DB.Exec(query, userUUID)
+	if err != nil {
+		return fmt.Errorf("error deleting totp credential: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error checking affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return common.ErrTOTPCredentialNotFound
+	}
+
+	return nil
+}
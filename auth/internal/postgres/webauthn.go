@@ -0,0 +1,197 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/lib/pq"
+	auth "github.com/medods-technical-assessment"
+	"github.com/medods-technical-assessment/internal/common"
+)
+
+const webAuthnCredentialColumns = `id, user_uuid, public_key, attestation_type, aaguid, transports, flags, sign_count, created_at, last_used_at`
+
+func (s *AuthService) AddWebAuthnSession(session *auth.WebAuthnSession) error {
+	// Abandoned ceremonies can't be finished anymore, so they are purged along the way
+	query := `
+        WITH purged AS (
+            DELETE FROM webauthn_sessions
+            WHERE expires_at < NOW()
+        )
+        INSERT INTO webauthn_sessions (uuid, user_uuid, ceremony, data, expires_at, created_at)
+        VALUES ($1, $2, $3, $4, $5, $6)`
+
+	_, err := s.DB.Exec(
+		query,
+		session.UUID,
+		session.UserUUID,
+		session.Ceremony,
+		session.Data,
+		session.ExpiresAt,
+		session.CreatedAt,
+	)
+
+	if err != nil {
+		return fmt.Errorf("error adding webauthn session: %w", err)
+	}
+
+	return nil
+}
+
+// Deletes the session and returns it, unless it has expired. Done in a single statement,
+// so that the challenge can't be answered twice, e.g. by replaying the same assertion
+func (s *AuthService) ConsumeWebAuthnSession(uuid auth.UUID, ceremony auth.WebAuthnCeremony) (*auth.WebAuthnSession, error) {
+	query := `
+        DELETE FROM webauthn_sessions
+        WHERE uuid = $1 AND
+              ceremony = $2
+        RETURNING uuid, user_uuid, ceremony, data, expires_at, created_at, expires_at >= NOW()`
+
+	var isActive bool
+	session := &auth.WebAuthnSession{}
+	err := s.DB.QueryRow(query, uuid, ceremony).Scan(
+		&session.UUID,
+		&session.UserUUID,
+		&session.Ceremony,
+		&session.Data,
+		&session.ExpiresAt,
+		&session.CreatedAt,
+		&isActive,
+	)
+
+	if err == sql.ErrNoRows {
+		return nil, common.ErrWebAuthnSessionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error consuming webauthn session: %w", err)
+	}
+	if !isActive {
+		return nil, common.ErrWebAuthnSessionNotFound
+	}
+
+	return session, nil
+}
+
+func (s *AuthService) AddWebAuthnCredential(credential *auth.WebAuthnCredential) error {
+	query := `
+        INSERT INTO webauthn_credentials (` + webAuthnCredentialColumns + `)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`
+
+	_, err := s.DB.Exec(
+		query,
+		credential.ID,
+		credential.UserUUID,
+		credential.PublicKey,
+		credential.AttestationType,
+		credential.AAGUID,
+		pq.Array(credential.Transports),
+		credential.Flags,
+		credential.SignCount,
+		credential.CreatedAt,
+		credential.LastUsedAt,
+	)
+
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			switch pqErr.Code {
+			case PgErrUniqueViolation:
+				if pqErr.Constraint == common.ConstraintWebAuthnCredentialPKey {
+					return common.ErrDuplicateWebAuthnCredential
+				}
+			}
+		}
+
+		return fmt.Errorf("error adding webauthn credential: %w", err)
+	}
+
+	return nil
+}
+
+func (s *AuthService) GetWebAuthnCredentialsByUser(userUUID auth.UUID) ([]*auth.WebAuthnCredential, error) {
+	credentials := make([]*auth.WebAuthnCredential, 0)
+	query := `
+        SELECT ` + webAuthnCredentialColumns + `
+        FROM webauthn_credentials
+        WHERE user_uuid = $1
+        ORDER BY created_at`
+
+	rows, err := s.DB.Query(query, userUUID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching webauthn credentials: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		credential := &auth.WebAuthnCredential{}
+		err := rows.Scan(
+			&credential.ID,
+			&credential.UserUUID,
+			&credential.PublicKey,
+			&credential.AttestationType,
+			&credential.AAGUID,
+			pq.Array(&credential.Transports),
+			&credential.Flags,
+			&credential.SignCount,
+			&credential.CreatedAt,
+			&credential.LastUsedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning webauthn credential: %w", err)
+		}
+		credentials = append(credentials, credential)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating webauthn credentials: %w", err)
+	}
+
+	return credentials, nil
+}
+
+// Keeps sign count and flags reported by the authenticator on login
+func (s *AuthService) UpdateWebAuthnCredentialUsage(credential *auth.WebAuthnCredential) error {
+	query := `
+        UPDATE webauthn_credentials
+        SET sign_count = $3,
+            flags = $4,
+            last_used_at = NOW()
+        WHERE id = $1 AND
+              user_uuid = $2`
+
+	result, err := s.DB.Exec(query, credential.ID, credential.UserUUID, credential.SignCount, credential.Flags)
+	if err != nil {
+		return fmt.Errorf("error updating webauthn credential: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error checking affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return common.ErrWebAuthnCredentialNotFound
+	}
+
+	return nil
+}
+
+func (s *AuthService) DeleteWebAuthnCredential(userUUID auth.UUID, id string) error {
+	query := `
+        DELETE FROM webauthn_credentials
+        WHERE id = $1 AND
+              user_uuid = $2`
+
+	result, err := s.DB.Exec(query, id, userUUID)
+	if err != nil {
+		return fmt.Errorf("error deleting webauthn credential: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error checking affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return common.ErrWebAuthnCredentialNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,170 @@
+package ratelimit
+
+import (
+	"math"
+	"net/netip"
+	"strings"
+	"time"
+
+	auth "github.com/medods-technical-assessment"
+)
+
+// Policy tells how long to wait before the next attempt, depending on the number of recent failures
+type Policy struct {
+	// Failures older than the window are forgotten
+	Window time.Duration
+	// Failures allowed without any delay, e.g. typos
+	FreeAttempts int
+	// Delay after the first failure beyond the free attempts, doubled by each following one
+	BaseDelay time.Duration
+	// Failures after which attempts are locked out for LockoutDuration since the last failure
+	LockoutThreshold int
+	LockoutDuration  time.Duration
+}
+
+var (
+	// Stops guessing of a single password after a few attempts, without locking out users who mistype it
+	DefaultAccountPolicy = Policy{
+		Window:           15 * time.Minute,
+		FreeAttempts:     3,
+		BaseDelay:        time.Second,
+		LockoutThreshold: 10,
+		LockoutDuration:  15 * time.Minute,
+	}
+	// Stops a single client from spraying passwords over many accounts, while leaving room for
+	// users behind the same NAT, e.g. staff of a clinic
+	DefaultIPPolicy = Policy{
+		Window:           15 * time.Minute,
+		FreeAttempts:     20,
+		BaseDelay:        time.Second,
+		LockoutThreshold: 100,
+		LockoutDuration:  15 * time.Minute,
+	}
+	// Stops flooding an inbox with password reset or sign-in emails, while letting the user ask again
+	// if an email got lost
+	DefaultEmailAccountPolicy = Policy{
+		Window:           time.Hour,
+		FreeAttempts:     3,
+		BaseDelay:        time.Minute,
+		LockoutThreshold: 10,
+		LockoutDuration:  time.Hour,
+	}
+	// Stops a single client from sending emails to many addresses
+	DefaultEmailIPPolicy = Policy{
+		Window:           time.Hour,
+		FreeAttempts:     20,
+		BaseDelay:        time.Second,
+		LockoutThreshold: 100,
+		LockoutDuration:  time.Hour,
+	}
+)
+
+// Returns how long to wait since the last failure before the next attempt
+func (p Policy) Delay(failures int) time.Duration {
+	if failures < p.FreeAttempts {
+		return 0
+	}
+	if failures >= p.LockoutThreshold {
+		return p.LockoutDuration
+	}
+
+	// Shift is capped, as the delay reaches the lockout duration long before it would overflow
+	delay := p.BaseDelay << min(failures-p.FreeAttempts, 32)
+	return min(delay, p.LockoutDuration)
+}
+
+// LoginLimiter counts login attempts per account and per client IP in sliding windows, taking back
+// the ones which turned out right, and delays further attempts progressively, eventually locking them out
+type LoginLimiter struct {
+	store auth.RateLimitStore
+	// Prefix of the counters' keys, so that limiters of different attempts can share the store
+	name          string
+	accountPolicy Policy
+	ipPolicy      Policy
+	now           func() time.Time
+}
+
+func NewLoginLimiter(store auth.RateLimitStore, name string, accountPolicy Policy, ipPolicy Policy) *LoginLimiter {
+	return &LoginLimiter{
+		store:         store,
+		name:          name,
+		accountPolicy: accountPolicy,
+		ipPolicy:      ipPolicy,
+		now:           time.Now,
+	}
+}
+
+func (l *LoginLimiter) Reserve(account string, ip string) (time.Duration, error) {
+	now := l.now()
+	accountWait, err := l.reserve(l.accountKey(account), l.accountPolicy, now)
+	if err != nil {
+		return 0, err
+	}
+	if ip == "" {
+		return accountWait, nil
+	}
+
+	ipWait, err := l.reserve(l.ipKey(ip), l.ipPolicy, now)
+	if err != nil {
+		return 0, err
+	}
+
+	return max(accountWait, ipWait), nil
+}
+
+func (l *LoginLimiter) Release(account string, ip string) error {
+	if err := l.store.Undo(l.accountKey(account)); err != nil {
+		return err
+	}
+	if ip == "" {
+		return nil
+	}
+
+	return l.store.Undo(l.ipKey(ip))
+}
+
+func (l *LoginLimiter) RecordSuccess(account string) error {
+	return l.store.Reset(l.accountKey(account))
+}
+
+// Hits the counter first and only then tells whether the hit came too early after the previous one,
+// so that concurrent attempts are told apart by the store rather than all seeing the same count
+func (l *LoginLimiter) reserve(key string, policy Policy, now time.Time) (time.Duration, error) {
+	counter, err := l.store.Hit(key, policy.Window, now)
+	if err != nil {
+		return 0, err
+	}
+	if counter.PreviousHitAt.IsZero() {
+		return 0, nil
+	}
+
+	// Rounded up, so that attempts of the previous window aren't forgotten before it ends
+	attempts := int(math.Ceil(counter.Count(policy.Window, now)))
+	if !now.Before(counter.PreviousHitAt.Add(policy.Delay(attempts - 1))) {
+		return 0, nil
+	}
+
+	// Counted as well, so the next attempt has to wait for the delay after this one
+	return policy.Delay(attempts), nil
+}
+
+// Emails are case-insensitive, so that changing the case doesn't get another set of attempts
+func (l *LoginLimiter) accountKey(account string) string {
+	return l.name + ":account:" + strings.ToLower(strings.TrimSpace(account))
+}
+
+// IPv6 clients usually have a whole /64 to pick addresses from, so it is counted as a single client
+func (l *LoginLimiter) ipKey(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return l.name + ":ip:" + ip
+	}
+
+	addr = addr.Unmap()
+	if addr.Is6() {
+		prefix, _ := addr.Prefix(64)
+		return l.name + ":ip:" + prefix.String()
+	}
+
+	return l.name + ":ip:" + addr.String()
+}
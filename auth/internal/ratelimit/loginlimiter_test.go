@@ -0,0 +1,195 @@
+package ratelimit
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/medods-technical-assessment/internal/memory"
+)
+
+var testPolicy = Policy{
+	Window:           time.Hour,
+	FreeAttempts:     3,
+	BaseDelay:        time.Second,
+	LockoutThreshold: 6,
+	LockoutDuration:  time.Minute,
+}
+
+func newTestLoginLimiter(now *time.Time) *LoginLimiter {
+	l := NewLoginLimiter(memory.NewRateLimitStore(), "login", testPolicy, testPolicy)
+	l.now = func() time.Time { return *now }
+	return l
+}
+
+func TestPolicyDelay(t *testing.T) {
+	var tests = []struct {
+		failures  int
+		wantDelay time.Duration
+	}{
+		{0, 0},
+		{2, 0},
+		{3, time.Second},
+		{4, 2 * time.Second},
+		{5, 4 * time.Second},
+		{6, time.Minute},
+		{100, time.Minute},
+	}
+
+	for _, tt := range tests {
+		if delay := testPolicy.Delay(tt.failures); delay != tt.wantDelay {
+			t.Errorf("got delay %v after %v failures, want delay %v", delay, tt.failures, tt.wantDelay)
+		}
+	}
+
+	policy := testPolicy
+	policy.LockoutThreshold = 1000
+	if delay := policy.Delay(999); delay != policy.LockoutDuration {
+		t.Errorf("got delay %v, want delay capped at %v", delay, policy.LockoutDuration)
+	}
+}
+
+func TestLoginLimiterProgressiveDelay(t *testing.T) {
+	now := time.Now().Truncate(time.Hour)
+	l := newTestLoginLimiter(&now)
+
+	var tests = []struct {
+		name     string
+		after    time.Duration
+		wantWait time.Duration
+	}{
+		{"First free attempt", 0, 0},
+		{"Second free attempt", 0, 0},
+		{"Third free attempt", 0, 0},
+		{"Too early after free attempts", 0, 2 * time.Second},
+		{"After waiting", 2 * time.Second, 0},
+		{"After doubled delay", 4 * time.Second, 0},
+		{"Locked out", 0, time.Minute},
+		{"Still locked out", 30 * time.Second, time.Minute},
+		{"After lockout", time.Minute, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now = now.Add(tt.after)
+
+			wait, err := l.Reserve("user@example.com", "192.0.2.1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if wait != tt.wantWait {
+				t.Errorf("got wait %v, want wait %v", wait, tt.wantWait)
+			}
+		})
+	}
+}
+
+func TestLoginLimiterConcurrentAttempts(t *testing.T) {
+	now := time.Now().Truncate(time.Hour)
+	l := newTestLoginLimiter(&now)
+
+	var wg sync.WaitGroup
+	var allowed atomic.Int32
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if wait, err := l.Reserve("user@example.com", "192.0.2.1"); err == nil && wait == 0 {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if int(allowed.Load()) != testPolicy.FreeAttempts {
+		t.Errorf("got %v concurrent attempts allowed, want %v", allowed.Load(), testPolicy.FreeAttempts)
+	}
+}
+
+func TestLoginLimiterKeys(t *testing.T) {
+	var tests = []struct {
+		name        string
+		failedEmail string
+		failedIP    string
+		email       string
+		ip          string
+		wantWait    time.Duration
+	}{
+		{"Same account from another ip", "user@example.com", "192.0.2.1", "user@example.com", "192.0.2.2", 2 * time.Second},
+		{"Email case is ignored", "User@Example.com", "192.0.2.1", "user@example.com", "192.0.2.2", 2 * time.Second},
+		{"Another account from same ip", "user@example.com", "192.0.2.1", "other@example.com", "192.0.2.1", 2 * time.Second},
+		{"Another account from same ipv6 network", "user@example.com", "2001:db8::1", "other@example.com", "2001:db8::2", 2 * time.Second},
+		{"Another account from another ipv6 network", "user@example.com", "2001:db8::1", "other@example.com", "2001:db8:0:1::1", 0},
+		{"Another account from another ip", "user@example.com", "192.0.2.1", "other@example.com", "192.0.2.2", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Now().Truncate(time.Hour)
+			l := newTestLoginLimiter(&now)
+			for range testPolicy.FreeAttempts {
+				l.Reserve(tt.failedEmail, tt.failedIP)
+			}
+
+			wait, err := l.Reserve(tt.email, tt.ip)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if wait != tt.wantWait {
+				t.Errorf("got wait %v, want wait %v", wait, tt.wantWait)
+			}
+		})
+	}
+}
+
+func TestLoginLimiterRelease(t *testing.T) {
+	now := time.Now().Truncate(time.Hour)
+	l := newTestLoginLimiter(&now)
+
+	for i := range 2 * testPolicy.LockoutThreshold {
+		wait, err := l.Reserve("user@example.com", "192.0.2.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wait != 0 {
+			t.Fatalf("got wait %v after %v released attempts, want no wait", wait, i)
+		}
+		if err = l.Release("user@example.com", "192.0.2.1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLoginLimiterRecordSuccess(t *testing.T) {
+	now := time.Now().Truncate(time.Hour)
+	l := newTestLoginLimiter(&now)
+
+	for range 5 {
+		l.Reserve("user@example.com", "192.0.2.1")
+	}
+	if err := l.RecordSuccess("USER@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	if wait, _ := l.Reserve("user@example.com", ""); wait != 0 {
+		t.Errorf("got wait %v for account after success, want no wait", wait)
+	}
+	if wait, _ := l.Reserve("other@example.com", "192.0.2.1"); wait == 0 {
+		t.Errorf("got no wait for ip after success, want wait")
+	}
+}
+
+func TestLoginLimitersSharingStore(t *testing.T) {
+	store := memory.NewRateLimitStore()
+	login := NewLoginLimiter(store, "login", testPolicy, testPolicy)
+	email := NewLoginLimiter(store, "email", testPolicy, testPolicy)
+
+	for range testPolicy.LockoutThreshold {
+		login.Reserve("user@example.com", "192.0.2.1")
+	}
+
+	if wait, _ := email.Reserve("user@example.com", "192.0.2.1"); wait != 0 {
+		t.Errorf("got wait %v of another limiter, want no wait", wait)
+	}
+}
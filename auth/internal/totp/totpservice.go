@@ -0,0 +1,118 @@
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Parameters understood by all common authenticator apps
+// ref: https://github.com/google/google-authenticator/wiki/Key-Uri-Format
+const (
+	secretLength = 20
+	digits       = 6
+	period       = 30 * time.Second
+	// Codes of adjacent time steps are accepted as well, to allow for clock drift
+	// and the time it takes to type the code in
+	skew = 1
+)
+
+var base32NoPadding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TOTPService implements time-based one-time passwords
+// ref: https://datatracker.ietf.org/doc/html/rfc6238
+type TOTPService struct {
+	// Shown in authenticator apps next to the account name
+	issuer string
+}
+
+func NewTOTPService(issuer string) *TOTPService {
+	if issuer == "" {
+		log.Panic(fmt.Errorf("error creating totp service: issuer is empty"))
+	}
+
+	return &TOTPService{
+		issuer: issuer,
+	}
+}
+
+// Returns random base32 encoded secret, shared with the authenticator app
+func (s *TOTPService) GenerateSecret() (string, error) {
+	secret := make([]byte, secretLength)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("error generating totp secret: %w", err)
+	}
+
+	return base32NoPadding.EncodeToString(secret), nil
+}
+
+// Returns otpauth:// URI, usually shown as a QR code, which adds the account to an authenticator app
+func (s *TOTPService) GetURI(secret string, accountName string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", s.issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(digits))
+	query.Set("period", fmt.Sprint(int(period.Seconds())))
+
+	uri := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + s.issuer + ":" + accountName,
+		RawQuery: query.Encode(),
+	}
+
+	return uri.String()
+}
+
+// Returns the time step the code was generated for, so that the caller can reject codes
+// of steps which have already been used
+func (s *TOTPService) Validate(secret string, code string, at time.Time) (int64, error) {
+	key, err := base32NoPadding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return 0, fmt.Errorf("invalid totp secret: %w", err)
+	}
+
+	if len(code) != digits {
+		return 0, fmt.Errorf("invalid code")
+	}
+
+	step := at.Unix() / int64(period.Seconds())
+	for i := int64(-skew); i <= skew; i++ {
+		expected := generateCode(key, step+i, digits)
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step + i, nil
+		}
+	}
+
+	return 0, fmt.Errorf("invalid code")
+}
+
+// HOTP value of the counter, truncated to the number of digits
+// ref: https://datatracker.ietf.org/doc/html/rfc4226#section-5.3
+func generateCode(key []byte, counter int64, digits int) string {
+	message := make([]byte, 8)
+	binary.BigEndian.PutUint64(message, uint64(counter))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(message)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	modulo := uint32(1)
+	for range digits {
+		modulo *= 10
+	}
+
+	return fmt.Sprintf("%0*d", digits, value%modulo)
+}
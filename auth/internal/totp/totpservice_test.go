@@ -0,0 +1,116 @@
+package totp
+
+import (
+	"encoding/base32"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// ref: https://datatracker.ietf.org/doc/html/rfc6238#appendix-B
+func TestGenerateCode(t *testing.T) {
+	key := []byte("12345678901234567890")
+
+	var tests = []struct {
+		name     string
+		unixTime int64
+		want     string
+	}{
+		{"RFC 6238 test vector", 59, "94287082"},
+		{"RFC 6238 test vector", 1111111109, "07081804"},
+		{"RFC 6238 test vector", 1111111111, "14050471"},
+		{"RFC 6238 test vector", 1234567890, "89005924"},
+		{"RFC 6238 test vector", 2000000000, "69279037"},
+		{"RFC 6238 test vector", 20000000000, "65353130"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := generateCode(key, tt.unixTime/30, 8)
+
+			if got != tt.want {
+				t.Errorf("got code %v, want code %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTOTPServiceValidate(t *testing.T) {
+	ts := NewTOTPService("auth")
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+	at := time.Unix(1111111111, 0)
+	step := at.Unix() / 30
+
+	var tests = []struct {
+		name      string
+		code      string
+		wantValid bool
+		wantStep  int64
+	}{
+		{"Current code", generateCode([]byte("12345678901234567890"), step, 6), true, step},
+		{"Previous code", generateCode([]byte("12345678901234567890"), step-1, 6), true, step - 1},
+		{"Next code", generateCode([]byte("12345678901234567890"), step+1, 6), true, step + 1},
+		{"Outdated code", generateCode([]byte("12345678901234567890"), step-2, 6), false, 0},
+		{"Code of other length", generateCode([]byte("12345678901234567890"), step, 8), false, 0},
+		{"Empty code", "", false, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotStep, err := ts.Validate(secret, tt.code, at)
+			isValid := err == nil
+
+			if isValid != tt.wantValid {
+				t.Errorf("got valid %v, want valid %v (%v)", isValid, tt.wantValid, err)
+				return
+			}
+			if gotStep != tt.wantStep {
+				t.Errorf("got step %v, want step %v", gotStep, tt.wantStep)
+			}
+		})
+	}
+}
+
+func TestTOTPServiceGenerateSecret(t *testing.T) {
+	ts := NewTOTPService("auth")
+
+	secret, err := ts.GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		t.Fatalf("got secret which isn't base32: %v", err)
+	}
+	if len(key) != secretLength {
+		t.Errorf("got secret length %v, want secret length %v", len(key), secretLength)
+	}
+
+	code := generateCode(key, time.Now().Unix()/30, 6)
+	if _, err := ts.Validate(secret, code, time.Now()); err != nil {
+		t.Errorf("got code of generated secret invalid: %v", err)
+	}
+}
+
+func TestTOTPServiceGetURI(t *testing.T) {
+	ts := NewTOTPService("auth")
+
+	uri, err := url.Parse(ts.GetURI("JBSWY3DPEHPK3PXP", "email@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if uri.Scheme != "otpauth" || uri.Host != "totp" {
+		t.Errorf("got uri %v, want otpauth://totp/ uri", uri)
+	}
+	if uri.Path != "/auth:email@example.com" {
+		t.Errorf("got label %v, want label %v", uri.Path, "/auth:email@example.com")
+	}
+	if got := uri.Query().Get("secret"); got != "JBSWY3DPEHPK3PXP" {
+		t.Errorf("got secret %v, want secret %v", got, "JBSWY3DPEHPK3PXP")
+	}
+	if got := uri.Query().Get("issuer"); got != "auth" {
+		t.Errorf("got issuer %v, want issuer %v", got, "auth")
+	}
+}
@@ -73,7 +73,7 @@ func (s *ValidationService) ValidateUserInput(input any) []auth.ValidationError
 			var message string
 
 			switch err.Tag() {
-			case "required":
+			case "required", "required_without":
 				message = fmt.Sprintf("%s is required", err.Field())
 			case "email":
 				message = "invalid email format"
@@ -81,6 +81,10 @@ func (s *ValidationService) ValidateUserInput(input any) []auth.ValidationError
 				message = fmt.Sprintf("%s must be at least %s characters long", err.Field(), err.Param())
 			case "max":
 				message = fmt.Sprintf("%s must not exceed %s characters", err.Field(), err.Param())
+			case "len":
+				message = fmt.Sprintf("%s must be %s characters long", err.Field(), err.Param())
+			case "numeric":
+				message = fmt.Sprintf("%s must only contain digits", err.Field())
 			case "password":
 				message = "password must contain at least one uppercase letter, one lowercase letter, one number, and one special character"
 			default:
@@ -97,3 +97,58 @@ func TestValidationService(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateTOTPCode(t *testing.T) {
+	var tests = []struct {
+		name        string
+		input       *auth.TOTPCodeDto
+		wantMessage string
+	}{
+		{"Valid code", &auth.TOTPCodeDto{Code: "012345"}, ""},
+		{"Missing code", &auth.TOTPCodeDto{}, "code is required"},
+		{"Code with letters", &auth.TOTPCodeDto{Code: "01234a"}, "code must only contain digits"},
+		{"Short code", &auth.TOTPCodeDto{Code: "01234"}, "code must be 6 characters long"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vs := NewValidationService()
+			ans := vs.ValidateUserInput(tt.input)
+
+			message := ""
+			if len(ans) > 0 {
+				message = ans[0].Message
+			}
+			if message != tt.wantMessage {
+				t.Errorf("got message %q, want message %q", message, tt.wantMessage)
+			}
+		})
+	}
+}
+
+func TestValidateLoginMFA(t *testing.T) {
+	var tests = []struct {
+		name      string
+		input     *auth.LoginMFADto
+		wantValid bool
+	}{
+		{"Valid code", &auth.LoginMFADto{MFAToken: "token", Code: "012345"}, true},
+		{"Valid recovery code", &auth.LoginMFADto{MFAToken: "token", RecoveryCode: "abcd-efgh-ijkl"}, true},
+		{"Both code and recovery code", &auth.LoginMFADto{MFAToken: "token", Code: "012345", RecoveryCode: "abcd-efgh-ijkl"}, false},
+		{"Neither code nor recovery code", &auth.LoginMFADto{MFAToken: "token"}, false},
+		{"Invalid code", &auth.LoginMFADto{MFAToken: "token", Code: "01234a"}, false},
+		{"Missing mfa token", &auth.LoginMFADto{Code: "012345"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vs := NewValidationService()
+			ans := vs.ValidateUserInput(tt.input)
+			isValid := ans == nil
+
+			if isValid != tt.wantValid {
+				t.Errorf("got valid %v, want valid %v (%v)", isValid, tt.wantValid, ans)
+			}
+		})
+	}
+}
@@ -0,0 +1,242 @@
+package webauthn
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	gowebauthn "github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+	auth "github.com/medods-technical-assessment"
+	"github.com/medods-technical-assessment/pkg/utils"
+)
+
+// Ceremonies the user doesn't finish in time are rejected, the client is asked to give up by then as well
+const ceremonyTimeout = 5 * time.Minute
+
+// WebAuthnService implements passkey registration and login on top of go-webauthn
+type WebAuthnService struct {
+	webAuthn *gowebauthn.WebAuthn
+}
+
+// Relying party ID is the domain passkeys are bound to, origins are the web origins (scheme, host
+// and port) of the pages which run the ceremonies, which have to be on that domain or its subdomains
+func NewWebAuthnService(rpID string, rpName string, rpOrigins []string) *WebAuthnService {
+	webAuthn, err := gowebauthn.New(&gowebauthn.Config{
+		RPID:          rpID,
+		RPDisplayName: rpName,
+		RPOrigins:     rpOrigins,
+		// Attestation is only useful to restrict authenticator models, which patients can't be expected to pick
+		AttestationPreference: protocol.PreferNoAttestation,
+		Timeouts: gowebauthn.TimeoutsConfig{
+			Login:        gowebauthn.TimeoutConfig{Enforce: true, Timeout: ceremonyTimeout, TimeoutUVD: ceremonyTimeout},
+			Registration: gowebauthn.TimeoutConfig{Enforce: true, Timeout: ceremonyTimeout, TimeoutUVD: ceremonyTimeout},
+		},
+	})
+	if err != nil {
+		log.Panic(fmt.Errorf("error creating webauthn service: %w", err))
+	}
+
+	return &WebAuthnService{
+		webAuthn: webAuthn,
+	}
+}
+
+// Asks for a discoverable credential, i.e. a passkey, so that the user can log in without typing
+// their email, and for user verification (PIN or biometrics), so that the passkey alone is enough
+func (s *WebAuthnService) BeginRegistration(user *auth.User, credentials []*auth.WebAuthnCredential) (any, []byte, error) {
+	webAuthnUser, err := newWebAuthnUser(user, credentials)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	options, session, err := s.webAuthn.BeginRegistration(
+		webAuthnUser,
+		gowebauthn.WithAuthenticatorSelection(protocol.AuthenticatorSelection{
+			ResidentKey:        protocol.ResidentKeyRequirementRequired,
+			RequireResidentKey: protocol.ResidentKeyRequired(),
+			UserVerification:   protocol.VerificationRequired,
+		}),
+		// So that the same authenticator isn't registered twice
+		gowebauthn.WithExclusions(gowebauthn.Credentials(webAuthnUser.credentials).CredentialDescriptors()),
+	)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error beginning webauthn registration: %w", err)
+	}
+
+	sessionData, err := json.Marshal(session)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error encoding webauthn session: %w", err)
+	}
+
+	return options, sessionData, nil
+}
+
+func (s *WebAuthnService) FinishRegistration(user *auth.User, credentials []*auth.WebAuthnCredential, session []byte, response []byte) (*auth.WebAuthnCredential, error) {
+	webAuthnUser, err := newWebAuthnUser(user, credentials)
+	if err != nil {
+		return nil, err
+	}
+
+	var sessionData gowebauthn.SessionData
+	if err = json.Unmarshal(session, &sessionData); err != nil {
+		return nil, fmt.Errorf("error decoding webauthn session: %w", err)
+	}
+
+	parsedResponse, err := protocol.ParseCredentialCreationResponseBytes(response)
+	if err != nil {
+		return nil, fmt.Errorf("invalid credential: %w", describeError(err))
+	}
+
+	credential, err := s.webAuthn.CreateCredential(webAuthnUser, sessionData, parsedResponse)
+	if err != nil {
+		return nil, fmt.Errorf("invalid credential: %w", describeError(err))
+	}
+
+	aaguid, err := uuid.FromBytes(credential.Authenticator.AAGUID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid credential aaguid: %w", err)
+	}
+
+	return &auth.WebAuthnCredential{
+		ID:              base64.RawURLEncoding.EncodeToString(credential.ID),
+		UserUUID:        user.UUID,
+		PublicKey:       credential.PublicKey,
+		AttestationType: credential.AttestationType,
+		AAGUID:          aaguid,
+		Transports: utils.MapSlice(credential.Transport, func(transport protocol.AuthenticatorTransport) string {
+			return string(transport)
+		}),
+		Flags:     byte(credential.Flags.ProtocolValue()),
+		SignCount: credential.Authenticator.SignCount,
+		CreatedAt: time.Now(),
+	}, nil
+}
+
+func (s *WebAuthnService) BeginLogin() (any, []byte, error) {
+	options, session, err := s.webAuthn.BeginDiscoverableLogin(gowebauthn.WithUserVerification(protocol.VerificationRequired))
+	if err != nil {
+		return nil, nil, fmt.Errorf("error beginning webauthn login: %w", err)
+	}
+
+	sessionData, err := json.Marshal(session)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error encoding webauthn session: %w", err)
+	}
+
+	return options, sessionData, nil
+}
+
+func (s *WebAuthnService) FinishLogin(session []byte, response []byte, getUser auth.WebAuthnUserHandler) (*auth.User, *auth.WebAuthnCredential, error) {
+	var sessionData gowebauthn.SessionData
+	if err := json.Unmarshal(session, &sessionData); err != nil {
+		return nil, nil, fmt.Errorf("error decoding webauthn session: %w", err)
+	}
+
+	parsedResponse, err := protocol.ParseCredentialRequestResponseBytes(response)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid assertion: %w", describeError(err))
+	}
+
+	// User handle is the UUID of the user, set on registration
+	handler := func(rawID, userHandle []byte) (gowebauthn.User, error) {
+		userUUID, err := uuid.FromBytes(userHandle)
+		if err != nil {
+			return nil, fmt.Errorf("invalid user handle: %w", err)
+		}
+		user, credentials, err := getUser(userUUID)
+		if err != nil {
+			return nil, err
+		}
+		return newWebAuthnUser(user, credentials)
+	}
+
+	validatedUser, credential, err := s.webAuthn.ValidatePasskeyLogin(handler, sessionData, parsedResponse)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid assertion: %w", describeError(err))
+	}
+
+	webAuthnUser := validatedUser.(*webAuthnUser)
+	id := base64.RawURLEncoding.EncodeToString(credential.ID)
+	index := slices.IndexFunc(webAuthnUser.stored, func(stored *auth.WebAuthnCredential) bool {
+		return stored.ID == id
+	})
+	if index == -1 {
+		return nil, nil, fmt.Errorf("invalid assertion: credential not found")
+	}
+
+	usedCredential := *webAuthnUser.stored[index]
+	usedCredential.SignCount = credential.Authenticator.SignCount
+	usedCredential.CloneWarning = credential.Authenticator.CloneWarning
+	usedCredential.Flags = byte(parsedResponse.Response.AuthenticatorData.Flags)
+
+	return webAuthnUser.user, &usedCredential, nil
+}
+
+// Adapts the user and their passkeys to what go-webauthn expects
+type webAuthnUser struct {
+	user        *auth.User
+	stored      []*auth.WebAuthnCredential
+	credentials []gowebauthn.Credential
+}
+
+func newWebAuthnUser(user *auth.User, stored []*auth.WebAuthnCredential) (*webAuthnUser, error) {
+	credentials := make([]gowebauthn.Credential, 0, len(stored))
+	for _, credential := range stored {
+		id, err := base64.RawURLEncoding.DecodeString(credential.ID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid credential id %s: %w", credential.ID, err)
+		}
+
+		credentials = append(credentials, gowebauthn.Credential{
+			ID:              id,
+			PublicKey:       credential.PublicKey,
+			AttestationType: credential.AttestationType,
+			Transport: utils.MapSlice(credential.Transports, func(transport string) protocol.AuthenticatorTransport {
+				return protocol.AuthenticatorTransport(transport)
+			}),
+			Flags: gowebauthn.NewCredentialFlags(protocol.AuthenticatorFlags(credential.Flags)),
+			Authenticator: gowebauthn.Authenticator{
+				AAGUID:    credential.AAGUID[:],
+				SignCount: credential.SignCount,
+			},
+		})
+	}
+
+	return &webAuthnUser{
+		user:        user,
+		stored:      stored,
+		credentials: credentials,
+	}, nil
+}
+
+// Random UUID isn't personal data, so it can be kept by the authenticator as the user handle
+func (u *webAuthnUser) WebAuthnID() []byte {
+	return u.user.UUID[:]
+}
+
+func (u *webAuthnUser) WebAuthnName() string {
+	return u.user.Email
+}
+
+func (u *webAuthnUser) WebAuthnDisplayName() string {
+	return u.user.Email
+}
+
+func (u *webAuthnUser) WebAuthnCredentials() []gowebauthn.Credential {
+	return u.credentials
+}
+
+// go-webauthn errors only print the details, while the actual reason is often in the debug info
+func describeError(err error) error {
+	var protocolErr *protocol.Error
+	if errors.As(err, &protocolErr) && protocolErr.DevInfo != "" {
+		return fmt.Errorf("%w: %s", err, protocolErr.DevInfo)
+	}
+	return err
+}
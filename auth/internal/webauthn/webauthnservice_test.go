@@ -0,0 +1,375 @@
+package webauthn
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+	"github.com/google/uuid"
+	auth "github.com/medods-technical-assessment"
+)
+
+const (
+	testRPID   = "localhost"
+	testOrigin = "http://localhost:3000"
+)
+
+// Authenticator data flags
+// ref: https://www.w3.org/TR/webauthn-3/#sctn-authenticator-data
+const (
+	flagUserPresent            = 0x01
+	flagUserVerified           = 0x04
+	flagAttestedCredentialData = 0x40
+)
+
+// softAuthenticator plays the part of the browser and the authenticator, producing responses
+// the way `navigator.credentials.create()` and `get()` would, with "none" attestation
+type softAuthenticator struct {
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	userHandle   []byte
+	signCount    uint32
+	origin       string
+	flags        byte
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credentialID := make([]byte, 16)
+	if _, err := rand.Read(credentialID); err != nil {
+		t.Fatal(err)
+	}
+
+	return &softAuthenticator{
+		key:          key,
+		credentialID: credentialID,
+		origin:       testOrigin,
+		flags:        flagUserPresent | flagUserVerified,
+	}
+}
+
+// Options as the browser gets them, i.e. serialized to JSON by the API
+type testOptions struct {
+	PublicKey struct {
+		Challenge string `json:"challenge"`
+		RPID      string `json:"rpId"`
+		RP        struct {
+			ID string `json:"id"`
+		} `json:"rp"`
+		User struct {
+			ID string `json:"id"`
+		} `json:"user"`
+	} `json:"publicKey"`
+}
+
+func decodeOptions(t *testing.T, options any) *testOptions {
+	data, err := json.Marshal(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded testOptions
+	if err = json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	return &decoded
+}
+
+func (a *softAuthenticator) clientData(ceremonyType string, challenge string) []byte {
+	return []byte(fmt.Sprintf(`{"type":%q,"challenge":%q,"origin":%q,"crossOrigin":false}`, ceremonyType, challenge, a.origin))
+}
+
+func (a *softAuthenticator) authData(rpID string, flags byte, extra []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	authData := append(rpIDHash[:], flags)
+	authData = binary.BigEndian.AppendUint32(authData, a.signCount)
+	return append(authData, extra...)
+}
+
+func (a *softAuthenticator) create(t *testing.T, options any) []byte {
+	decoded := decodeOptions(t, options)
+	userHandle, err := base64.RawURLEncoding.DecodeString(decoded.PublicKey.User.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.userHandle = userHandle
+
+	publicKey, err := a.key.PublicKey.ECDH()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Uncompressed point, i.e. 0x04 followed by the coordinates
+	point := publicKey.Bytes()
+	coseKey, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{
+			KeyType:   int64(webauthncose.EllipticKey),
+			Algorithm: int64(webauthncose.AlgES256),
+		},
+		Curve:  1, // P-256
+		XCoord: point[1:33],
+		YCoord: point[33:],
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	attestedCredentialData := make([]byte, 16) // AAGUID, zeros with "none" attestation
+	attestedCredentialData = binary.BigEndian.AppendUint16(attestedCredentialData, uint16(len(a.credentialID)))
+	attestedCredentialData = append(attestedCredentialData, a.credentialID...)
+	attestedCredentialData = append(attestedCredentialData, coseKey...)
+
+	attestationObject, err := webauthncbor.Marshal(struct {
+		Format    string         `cbor:"fmt"`
+		Statement map[string]any `cbor:"attStmt"`
+		AuthData  []byte         `cbor:"authData"`
+	}{
+		Format:    "none",
+		Statement: map[string]any{},
+		AuthData:  a.authData(decoded.PublicKey.RP.ID, a.flags|flagAttestedCredentialData, attestedCredentialData),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return a.marshalCredential(t, map[string]any{
+		"clientDataJSON":    a.clientData("webauthn.create", decoded.PublicKey.Challenge),
+		"attestationObject": attestationObject,
+		"transports":        []string{"internal"},
+	})
+}
+
+func (a *softAuthenticator) get(t *testing.T, options any) []byte {
+	decoded := decodeOptions(t, options)
+	a.signCount++
+
+	clientData := a.clientData("webauthn.get", decoded.PublicKey.Challenge)
+	authData := a.authData(decoded.PublicKey.RPID, a.flags, nil)
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(authData, clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return a.marshalCredential(t, map[string]any{
+		"clientDataJSON":    clientData,
+		"authenticatorData": authData,
+		"signature":         signature,
+		"userHandle":        a.userHandle,
+	})
+}
+
+// Binary members are base64url encoded, as done by `PublicKeyCredential.toJSON()`
+func (a *softAuthenticator) marshalCredential(t *testing.T, response map[string]any) []byte {
+	encoded := make(map[string]any, len(response))
+	for name, value := range response {
+		if data, ok := value.([]byte); ok {
+			encoded[name] = base64.RawURLEncoding.EncodeToString(data)
+		} else {
+			encoded[name] = value
+		}
+	}
+
+	credential, err := json.Marshal(map[string]any{
+		"id":       base64.RawURLEncoding.EncodeToString(a.credentialID),
+		"rawId":    base64.RawURLEncoding.EncodeToString(a.credentialID),
+		"type":     "public-key",
+		"response": encoded,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return credential
+}
+
+func newTestUser() *auth.User {
+	return &auth.User{UUID: uuid.New(), Email: "email@example.com", EmailVerified: true}
+}
+
+// Registers a passkey of the authenticator for the user, as the API does
+func register(t *testing.T, ws *WebAuthnService, user *auth.User, authenticator *softAuthenticator) *auth.WebAuthnCredential {
+	options, session, err := ws.BeginRegistration(user, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	credential, err := ws.FinishRegistration(user, nil, session, authenticator.create(t, options))
+	if err != nil {
+		t.Fatalf("got registration error: %v", err)
+	}
+	return credential
+}
+
+func TestWebAuthnServiceRegistration(t *testing.T) {
+	ws := NewWebAuthnService(testRPID, "auth", []string{testOrigin})
+	user := newTestUser()
+	authenticator := newSoftAuthenticator(t)
+
+	credential := register(t, ws, user, authenticator)
+
+	if want := base64.RawURLEncoding.EncodeToString(authenticator.credentialID); credential.ID != want {
+		t.Errorf("got credential id %v, want credential id %v", credential.ID, want)
+	}
+	if credential.UserUUID != user.UUID {
+		t.Errorf("got user uuid %v, want user uuid %v", credential.UserUUID, user.UUID)
+	}
+	if credential.AttestationType != "none" {
+		t.Errorf("got attestation type %v, want attestation type %v", credential.AttestationType, "none")
+	}
+	if len(credential.Transports) != 1 || credential.Transports[0] != "internal" {
+		t.Errorf("got transports %v, want transports %v", credential.Transports, []string{"internal"})
+	}
+	if string(authenticator.userHandle) != string(user.UUID[:]) {
+		t.Errorf("got user handle %x, want user handle %x", authenticator.userHandle, user.UUID[:])
+	}
+}
+
+func TestWebAuthnServiceRegistrationRejected(t *testing.T) {
+	ws := NewWebAuthnService(testRPID, "auth", []string{testOrigin})
+
+	var tests = []struct {
+		name   string
+		modify func(authenticator *softAuthenticator)
+	}{
+		{"Other origin", func(a *softAuthenticator) { a.origin = "https://example.com" }},
+		{"No user verification", func(a *softAuthenticator) { a.flags = flagUserPresent }},
+		{"No user presence", func(a *softAuthenticator) { a.flags = flagUserVerified }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := newTestUser()
+			authenticator := newSoftAuthenticator(t)
+			tt.modify(authenticator)
+
+			options, session, err := ws.BeginRegistration(user, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = ws.FinishRegistration(user, nil, session, authenticator.create(t, options))
+
+			if err == nil {
+				t.Errorf("got credential registered, want registration rejected")
+			}
+		})
+	}
+
+	t.Run("Session of other user", func(t *testing.T) {
+		user, otherUser := newTestUser(), newTestUser()
+		authenticator := newSoftAuthenticator(t)
+
+		options, session, err := ws.BeginRegistration(otherUser, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = ws.FinishRegistration(user, nil, session, authenticator.create(t, options))
+
+		if err == nil {
+			t.Errorf("got credential registered, want registration rejected")
+		}
+	})
+}
+
+func TestWebAuthnServiceLogin(t *testing.T) {
+	ws := NewWebAuthnService(testRPID, "auth", []string{testOrigin})
+
+	var tests = []struct {
+		name             string
+		modify           func(authenticator *softAuthenticator)
+		wantValid        bool
+		wantCloneWarning bool
+	}{
+		{"Valid assertion", func(a *softAuthenticator) {}, true, false},
+		{"Other origin", func(a *softAuthenticator) { a.origin = "https://example.com" }, false, false},
+		{"No user verification", func(a *softAuthenticator) { a.flags = flagUserPresent }, false, false},
+		{"Other key", func(a *softAuthenticator) {
+			other := newSoftAuthenticator(t)
+			a.key = other.key
+		}, false, false},
+		{"Unknown credential", func(a *softAuthenticator) { a.credentialID = []byte("unknown") }, false, false},
+		{"Unknown user", func(a *softAuthenticator) {
+			otherUser := newTestUser()
+			a.userHandle = otherUser.UUID[:]
+		}, false, false},
+		{"Sign count not increased", func(a *softAuthenticator) { a.signCount = 0 }, true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := newTestUser()
+			authenticator := newSoftAuthenticator(t)
+			stored := register(t, ws, user, authenticator)
+			// As if the passkey has been used before
+			authenticator.signCount = 5
+			stored.SignCount = 5
+
+			getUser := func(userUUID auth.UUID) (*auth.User, []*auth.WebAuthnCredential, error) {
+				if userUUID != user.UUID {
+					return nil, nil, fmt.Errorf("user not found")
+				}
+				return user, []*auth.WebAuthnCredential{stored}, nil
+			}
+
+			options, session, err := ws.BeginLogin()
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.modify(authenticator)
+			gotUser, credential, err := ws.FinishLogin(session, authenticator.get(t, options), getUser)
+			isValid := err == nil
+
+			if isValid != tt.wantValid {
+				t.Errorf("got valid %v, want valid %v (%v)", isValid, tt.wantValid, err)
+				return
+			}
+			if !isValid {
+				return
+			}
+			if gotUser.UUID != user.UUID {
+				t.Errorf("got user %v, want user %v", gotUser.UUID, user.UUID)
+			}
+			if credential.ID != stored.ID {
+				t.Errorf("got credential id %v, want credential id %v", credential.ID, stored.ID)
+			}
+			if credential.CloneWarning != tt.wantCloneWarning {
+				t.Errorf("got clone warning %v, want clone warning %v", credential.CloneWarning, tt.wantCloneWarning)
+			}
+			if !tt.wantCloneWarning && credential.SignCount != authenticator.signCount {
+				t.Errorf("got sign count %v, want sign count %v", credential.SignCount, authenticator.signCount)
+			}
+		})
+	}
+
+	t.Run("Session of other ceremony", func(t *testing.T) {
+		user := newTestUser()
+		authenticator := newSoftAuthenticator(t)
+		stored := register(t, ws, user, authenticator)
+		getUser := func(userUUID auth.UUID) (*auth.User, []*auth.WebAuthnCredential, error) {
+			return user, []*auth.WebAuthnCredential{stored}, nil
+		}
+
+		options, _, err := ws.BeginLogin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, otherSession, err := ws.BeginLogin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _, err = ws.FinishLogin(otherSession, authenticator.get(t, options), getUser)
+
+		if err == nil {
+			t.Errorf("got login with challenge of other ceremony, want login rejected")
+		}
+	})
+}